@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/shogo82148/s3ftpgateway/vfs/s3fs"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/unicode/norm"
+)
+
+// AuditNormalization reports the keys under the prefixes of config
+// whose names collide in the Unicode normalization form.
+// They should be renamed or removed before enabling the normalization,
+// because only one of them is visible after that.
+// It returns the number of colliding groups.
+func AuditNormalization(config *Config, w io.Writer) int {
+	form, ok, err := normalizationForm(config.Normalization)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to parse s3ftpgateway config")
+	}
+	if !ok {
+		form = norm.NFC
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to get AWS config")
+	}
+
+	type target struct {
+		name string
+		fs   *s3fs.FileSystem
+	}
+	targets := []target{{"default", newFileSystem(cfg, config, nil, config.Bucket, config.Prefix)}}
+	for _, h := range config.Hosts {
+		bucket := h.Bucket
+		if bucket == "" {
+			bucket = config.Bucket
+		}
+		targets = append(targets, target{strings.ToLower(h.Name), newFileSystem(cfg, config, nil, bucket, h.Prefix)})
+	}
+
+	var count int
+	for _, t := range targets {
+		groups, err := t.fs.FindNormalizationCollisions(context.Background(), form)
+		if err != nil {
+			logrus.WithError(err).WithField("host", t.name).Fatal("fail to audit the keys")
+		}
+		for _, group := range groups {
+			quoted := make([]string, 0, len(group))
+			for _, name := range group {
+				quoted = append(quoted, fmt.Sprintf("%+q", name))
+			}
+			fmt.Fprintf(w, "%s\ts3://%s/%s\t%s\n", t.name, t.fs.Bucket, t.fs.Prefix, strings.Join(quoted, " "))
+		}
+		count += len(groups)
+	}
+	return count
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/shogo82148/s3ftpgateway/ftp"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/encoding"
 )
 
 // NewAuthorizer returns new authorizer.
@@ -38,9 +39,38 @@ func newUserListAuthorizer(config map[string]interface{}) (ftp.Authorizer, error
 		if !ok {
 			return nil, errors.New("password must be a string")
 		}
+		var siteCommands []string
+		if v, ok := u["site_commands"]; ok {
+			cmds, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.New("site_commands must be an array")
+			}
+			siteCommands = make([]string, 0, len(cmds))
+			for _, cmd := range cmds {
+				s, ok := cmd.(string)
+				if !ok {
+					return nil, errors.New("site_commands must be an array of strings")
+				}
+				siteCommands = append(siteCommands, s)
+			}
+		}
+		var charset encoding.Encoding
+		if v, ok := u["charset"]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("charset must be a string")
+			}
+			var err error
+			charset, err = lookupCharset(s)
+			if err != nil {
+				return nil, err
+			}
+		}
 		list = append(list, &authUser{
-			Name:     name,
-			Password: password,
+			Name:         name,
+			Password:     password,
+			SiteCommands: siteCommands,
+			Charset:      charset,
 		})
 	}
 	sort.Sort(list) // TODO: check duplicated user name.
@@ -52,6 +82,14 @@ func newUserListAuthorizer(config map[string]interface{}) (ftp.Authorizer, error
 type authUser struct {
 	Name     string
 	Password string
+
+	// SiteCommands are the SITE subcommands that the user can execute.
+	// If it is nil, the user can execute all subcommands except WHO.
+	SiteCommands []string
+
+	// Charset is the charset of the path names that the user's client sends.
+	// If it is nil, the charset of the listener is used.
+	Charset encoding.Encoding
 }
 
 type authUsers []*authUser
@@ -82,7 +120,9 @@ func (a userListAuthorizer) Authorize(ctx context.Context, conn *ftp.ServerConn,
 		return nil, ftp.ErrAuthorizeFailed
 	}
 	return &ftp.Authorization{
-		User:       user,
-		FileSystem: conn.Server().FileSystem,
+		User:         user,
+		FileSystem:   conn.Server().ResolveHost(conn).FileSystem,
+		SiteCommands: u.SiteCommands,
+		Charset:      u.Charset,
 	}, nil
 }
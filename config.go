@@ -2,7 +2,9 @@ package main
 
 import (
 	"os"
+	"time"
 
+	"github.com/shogo82148/s3ftpgateway/ftp"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -16,6 +18,37 @@ type Config struct {
 
 	Authorizer AuthorizerConfig `yaml:"authorizer"`
 
+	// Banner is the greeting message sent on connection, e.g. a legal notice.
+	// It may have multiple lines. The default is "Service ready".
+	Banner string `yaml:"banner"`
+
+	// LoginMessage is the message shown on login, e.g. the message of the day.
+	// It is a Go template, and it can refer to .User, .SessionID, .ClientIP,
+	// .Listener, .Host, .Time and .Quota.
+	// .Quota is nil unless the authorizer reports it, so refer to it in {{with .Quota}}.
+	LoginMessage string `yaml:"login_message"`
+
+	// DirMessage is the name of the message files, e.g. ".message".
+	// The content of the file is shown on changing into the directory.
+	// If it is empty, no message is shown.
+	DirMessage string `yaml:"dir_message"`
+
+	// Language is the default language of the reply messages, e.g. "ja".
+	// Clients can change it by LANG command. The default is English.
+	Language string `yaml:"language"`
+
+	// Messages are the reply messages that replace the built-in ones, keyed by the language tags.
+	// The keys of the messages are the English messages in the source code,
+	// e.g. {"en": {"Not logged in.": "Please log in first."}}.
+	Messages map[string]ftp.Catalog `yaml:"messages"`
+
+	// Hosts are the name-based virtual hosts.
+	// Clients select one by HOST command or SNI of TLS.
+	Hosts []HostConfig `yaml:"hosts"`
+
+	Upload   UploadConfig   `yaml:"upload"`
+	Download DownloadConfig `yaml:"download"`
+
 	// MinPassivePort is minimum port number for passive data connections.
 	// If MinPassivePort is more than MaxPassivePort, passive more is disabled.
 	MinPassivePort int `yaml:"min_passive_port"`
@@ -38,10 +71,66 @@ type Config struct {
 	// because it has some security risk, and most clients use passive mode.
 	EnableActiveMode bool `yaml:"enable_active_mode"`
 
+	// EnableCCC enables CCC (Clear Command Channel) command.
+	// It allows NAT firewalls to inspect the control connection of FTPS,
+	// but the commands after CCC are sent in plaintext.
+	EnableCCC bool `yaml:"enable_ccc"`
+
+	// EnableRestart enables REST and RANG commands for resuming downloads in binary type.
+	// Resuming uploads is not supported, because S3 objects are written at once.
+	EnableRestart bool `yaml:"enable_restart"`
+
 	// EnableAddressCheck enables checking address of data connection peer.
 	// The checking is enabled by default to avoid the bounce attack.
 	EnableAddressCheck bool `yaml:"enable_address_check"`
 
+	// FXP are the rules that permit the data connections with the peers other than the client,
+	// e.g. server-to-server transfers between gateways.
+	// They are effective when EnableAddressCheck is true.
+	FXP []FXPRuleConfig `yaml:"fxp"`
+
+	// IdleTimeout is the maximum amount of time to wait for the next command.
+	// If it is zero, there is no timeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// MaxIdleTimeout is the maximum idle timeout that users can set by SITE IDLE.
+	// If it is zero, IdleTimeout is used.
+	MaxIdleTimeout time.Duration `yaml:"max_idle_timeout"`
+
+	// ASCIIPassThrough disables the line ending conversion in ASCII mode (TYPE A).
+	// If it is true, files are transferred unchanged in ASCII mode.
+	ASCIIPassThrough bool `yaml:"ascii_pass_through"`
+
+	// RenameCompareAndSwap makes renaming fail if the file is changed
+	// by another session between RNFR and RNTO commands.
+	RenameCompareAndSwap bool `yaml:"rename_compare_and_swap"`
+
+	// ListMetadata makes LIST and NLST show the modes and the times set by clients,
+	// e.g. by SITE CHMOD and MFMT commands. It costs a HEAD request per file.
+	// MLSD always shows them regardless of this option, because the clients use its facts for synchronization.
+	ListMetadata bool `yaml:"list_metadata"`
+
+	// ListStyle is the format of directory listings, "unix" or "dos".
+	// The default is "unix".
+	ListStyle string `yaml:"list_style"`
+
+	// ListTimeZone is the name of the time zone in directory listings, e.g. "Asia/Tokyo".
+	// If it is empty, the local time zone is used.
+	ListTimeZone string `yaml:"list_time_zone"`
+
+	// MaxListDepth is the maximum depth of subdirectories in recursive listings (e.g. LIST -R).
+	// If it is zero, the default depth 16 is used.
+	MaxListDepth int `yaml:"max_list_depth"`
+
+	// Normalization is the Unicode normalization form of the names of new files, "nfc" or "nfd".
+	// The names in other forms are still found on lookup.
+	// If it is empty, the names are stored as the clients send.
+	Normalization string `yaml:"normalization"`
+
+	// MaxListEntries is the maximum number of entries in one listing.
+	// If it is zero, the default number 100000 is used.
+	MaxListEntries int `yaml:"max_list_entries"`
+
 	// Certificate is a file path for certificate public key.
 	// The file must contain PEM encoded data.
 	Certificate string `yaml:"certificate"`
@@ -58,6 +147,77 @@ type ListenerConfig struct {
 
 	// TLS enables implicit tls mode.
 	TLS bool `yaml:"tls"`
+
+	// Name is the name of the listener, used in templates of uploads.
+	// If it is empty, Address is used.
+	Name string `yaml:"name"`
+
+	// Charset is the charset of the path names that the clients send, for legacy clients.
+	// "utf-8", "shift_jis" and "euc-jp" are valid. The default is "utf-8".
+	// The clients can switch to UTF-8 by OPTS UTF8 ON.
+	Charset string `yaml:"charset"`
+
+	// Banner is the greeting message of the listener.
+	// If it is empty, the global banner is used.
+	Banner string `yaml:"banner"`
+
+	// LoginMessage is the message shown on login to the listener.
+	// If it is empty, the global login message is used.
+	LoginMessage string `yaml:"login_message"`
+
+	// DirMessage is the name of the message files of the listener.
+	// If it is empty, the global name is used.
+	DirMessage string `yaml:"dir_message"`
+}
+
+// HostConfig is the config of a virtual host.
+type HostConfig struct {
+	// Name is the host name, e.g. "ftp.example.com".
+	Name string `yaml:"name"`
+
+	// Bucket is the bucket of the host.
+	// If it is empty, the global bucket is used.
+	Bucket string `yaml:"bucket"`
+
+	// Prefix is the prefix of the keys of the host.
+	// It doesn't inherit the global prefix: if it is empty, the host exposes the whole bucket.
+	Prefix string `yaml:"prefix"`
+
+	// Authorizer authorizes the users of the host. It is required,
+	// because the users of the global authorizer must not select the host by HOST command.
+	Authorizer AuthorizerConfig `yaml:"authorizer"`
+
+	// Banner is the greeting message of the host.
+	Banner string `yaml:"banner"`
+
+	// LoginMessage is the message shown on login to the host.
+	// If it is empty, the login message of the listener or the global one is used.
+	LoginMessage string `yaml:"login_message"`
+
+	// DirMessage is the name of the message files of the host.
+	// If it is empty, the name of the listener or the global one is used.
+	DirMessage string `yaml:"dir_message"`
+
+	// Certificate is a file path for the certificate of the host, presented by SNI.
+	// If it is empty, the global certificate is used.
+	Certificate string `yaml:"certificate"`
+
+	// CertificateKey is a file path for the private key of the certificate.
+	CertificateKey string `yaml:"certificate_key"`
+}
+
+// FXPRuleConfig is the config for permitting server-to-server transfers (FXP).
+type FXPRuleConfig struct {
+	// User is the name of the user that the rule is applied to.
+	// If it is empty, the rule is applied to all users.
+	User string `yaml:"user"`
+
+	// Peers are the permitted peers, in CIDR notation (e.g. "192.0.2.0/24") or IP addresses.
+	Peers []string `yaml:"peers"`
+
+	// Mode is the mode of the data connections, "active" or "passive".
+	// If it is empty, both modes are permitted.
+	Mode string `yaml:"mode"`
 }
 
 // LogConfig is the config for log.
@@ -67,6 +227,82 @@ type LogConfig struct {
 	Format string `yaml:"format"`
 }
 
+// UploadConfig is the config for uploading files to S3.
+type UploadConfig struct {
+	// PartSize is the size in bytes of each part of multipart uploads.
+	// The minimum is 5MiB. If it is zero, the default of the AWS SDK is used.
+	PartSize int64 `yaml:"part_size"`
+
+	// Concurrency is the number of parts uploaded in parallel per file.
+	// If it is zero, the default of the AWS SDK is used.
+	Concurrency int `yaml:"concurrency"`
+
+	// BufferSize enables pooling of the buffers used for sending parts,
+	// and it is the size in bytes of each buffer.
+	BufferSize int `yaml:"buffer_size"`
+
+	// MaxSize is the maximum size in bytes of uploaded files.
+	// If it is zero, the size is not limited.
+	MaxSize int64 `yaml:"max_size"`
+
+	// ChecksumAlgorithm is the algorithm for verifying uploaded files end-to-end.
+	// "CRC32C" and "SHA256" are valid. If it is empty, uploaded files are not verified.
+	ChecksumAlgorithm string `yaml:"checksum_algorithm"`
+
+	// AbortIncompleteAfter is the age of incomplete multipart uploads to be aborted.
+	// They are searched at the start and every AbortIncompleteInterval.
+	// If it is zero, incomplete multipart uploads are left.
+	// The uploads in progress in this gateway are never aborted,
+	// but the ones of the other gateways on the same bucket are not known.
+	// If the gateways share the bucket, it must be longer than the longest upload.
+	AbortIncompleteAfter time.Duration `yaml:"abort_incomplete_after"`
+
+	// AbortIncompleteInterval is the interval for searching incomplete multipart uploads.
+	// The default is one hour.
+	AbortIncompleteInterval time.Duration `yaml:"abort_incomplete_interval"`
+
+	// Rules are the rules for the object tags and the metadata of uploaded files.
+	// All matching rules are applied in order.
+	Rules []UploadRuleConfig `yaml:"rules"`
+}
+
+// UploadRuleConfig is the config for the object tags and the metadata of uploaded files.
+// The values are Go templates, and they can refer to .User, .SessionID, .ClientIP,
+// .Listener, .Path and .Time.
+type UploadRuleConfig struct {
+	// User is the name of the user that the rule is applied to.
+	// If it is empty, the rule is applied to all users.
+	User string `yaml:"user"`
+
+	// PathPrefix is the prefix of the paths that the rule is applied to.
+	// If it is empty, the rule is applied to all paths.
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Tags are the templates of the object tags.
+	Tags map[string]string `yaml:"tags"`
+
+	// Metadata are the templates of the user-defined metadata.
+	Metadata map[string]string `yaml:"metadata"`
+
+	// NoOverwrite prohibits replacing existing files.
+	// Uploads and renames to existing files are rejected.
+	NoOverwrite bool `yaml:"no_overwrite"`
+}
+
+// DownloadConfig is the config for downloading files from S3.
+type DownloadConfig struct {
+	// Concurrency is the number of ranges of a file downloaded in parallel.
+	// If it is less than two, files are downloaded by a single request.
+	Concurrency int `yaml:"concurrency"`
+
+	// PartSize is the size in bytes of each range.
+	// If it is zero, the default of the AWS SDK is used.
+	PartSize int64 `yaml:"part_size"`
+
+	// Threshold is the minimum size in bytes of files downloaded in parallel.
+	Threshold int64 `yaml:"threshold"`
+}
+
 // AuthorizerConfig is config for authorize.
 type AuthorizerConfig struct {
 	Method string                 `yaml:"method"`
@@ -0,0 +1,186 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// the errors of parsing the addresses of the data connections.
+var (
+	errAddressSyntax  = errors.New("ftp: syntax error in the address")
+	errInvalidAddress = errors.New("ftp: invalid address")
+	errInvalidPort    = errors.New("ftp: invalid port number")
+)
+
+// unsupportedNetworkError is returned when the address family of the address isn't supported.
+// The value is the list of the supported families, e.g. "(1,2)".
+type unsupportedNetworkError string
+
+func (err unsupportedNetworkError) Error() string {
+	return "ftp: network protocol not supported, use " + string(err)
+}
+
+// parseBytes parses the comma separated decimal bytes, e.g. "192,168,0,1".
+func parseBytes(s string) ([]byte, error) {
+	fields := strings.Split(s, ",")
+	ret := make([]byte, 0, len(fields))
+	for _, f := range fields {
+		n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil {
+			return nil, errAddressSyntax
+		}
+		ret = append(ret, byte(n))
+	}
+	return ret, nil
+}
+
+// parsePortAddress parses the argument of PORT command, e.g. "192,168,0,1,4,1".
+func parsePortAddress(arg string) (*net.TCPAddr, error) {
+	b, err := parseBytes(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 6 {
+		return nil, errAddressSyntax
+	}
+	return &net.TCPAddr{
+		IP:   net.IPv4(b[0], b[1], b[2], b[3]),
+		Port: int(b[4])<<8 | int(b[5]),
+	}, nil
+}
+
+// parseEprtAddress parses the argument of EPRT command, e.g. "|1|192.168.0.1|1025|".
+// https://tools.ietf.org/html/rfc2428#section-2
+func parseEprtAddress(arg string) (*net.TCPAddr, error) {
+	if arg == "" {
+		return nil, errAddressSyntax
+	}
+	delim := arg[:1]
+	params := strings.Split(arg, delim)
+	if len(params) < 5 {
+		return nil, errAddressSyntax
+	}
+
+	var ip net.IP
+	switch params[1] {
+	case "1": // IP v4
+		ip = net.ParseIP(params[2])
+		if ip != nil {
+			ip = ip.To4()
+		}
+	case "2": // IP v6
+		ip = net.ParseIP(params[2])
+		if ip != nil {
+			ip = ip.To16()
+		}
+	default:
+		return nil, unsupportedNetworkError("(1,2)")
+	}
+	if ip == nil {
+		return nil, errInvalidAddress
+	}
+	port, err := strconv.Atoi(params[3])
+	if err != nil {
+		return nil, errInvalidPort
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// parseLprtAddress parses the argument of LPRT command.
+// It is the address family, the length of the address, the address, the length of the port number and the port number,
+// e.g. "4,4,192,168,0,1,2,4,1" or "6,16,32,1,...,2,4,1".
+// https://tools.ietf.org/html/rfc1639#section-2
+func parseLprtAddress(arg string) (*net.TCPAddr, error) {
+	b, err := parseBytes(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 {
+		return nil, errAddressSyntax
+	}
+
+	var ipLen int
+	switch b[0] {
+	case 4: // IP v4
+		ipLen = net.IPv4len
+	case 6: // IP v6
+		ipLen = net.IPv6len
+	default:
+		return nil, unsupportedNetworkError("(4,6)")
+	}
+	if int(b[1]) != ipLen || len(b) < 2+ipLen+1 {
+		return nil, errInvalidAddress
+	}
+	ip := net.IP(b[2 : 2+ipLen])
+
+	portBytes := b[2+ipLen+1:]
+	if int(b[2+ipLen]) != len(portBytes) || len(portBytes) == 0 || len(portBytes) > 2 {
+		return nil, errInvalidPort
+	}
+	var port int
+	for _, p := range portBytes {
+		port = port<<8 | int(p)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// formatLongAddress formats the address in the format of the reply of LPSV command.
+// https://tools.ietf.org/html/rfc1639#section-2
+func formatLongAddress(ip net.IP, port int) string {
+	var b []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{4, net.IPv4len}, ip4...)
+	} else {
+		b = append([]byte{6, net.IPv6len}, ip.To16()...)
+	}
+	b = append(b, 2, byte(port>>8), byte(port))
+
+	s := make([]string, 0, len(b))
+	for _, v := range b {
+		s = append(s, strconv.Itoa(int(v)))
+	}
+	return strings.Join(s, ",")
+}
+
+// executeActive executes the commands for active mode, PORT, EPRT and LPRT.
+// parse parses the address of the argument.
+func executeActive(ctx context.Context, c *ServerConn, cmd *Command, parse func(string) (*net.TCPAddr, error)) {
+	if c.epsvAll || !c.server.EnableActiveMode {
+		c.WriteReplyf(StatusBadArguments, "%s command is disabled.", cmd.Name)
+		return
+	}
+
+	addr, err := parse(cmd.Arg)
+	if err != nil {
+		var unsupported unsupportedNetworkError
+		switch {
+		case errors.As(err, &unsupported):
+			c.WriteReplyf(StatusNetworkProtoNotSupported, "Network protocol not supported, use %s", string(unsupported))
+		case errors.Is(err, errInvalidAddress):
+			c.WriteReply(StatusBadArguments, "Invalid address.")
+		case errors.Is(err, errInvalidPort):
+			c.WriteReply(StatusBadArguments, "Invalid port number.")
+		default:
+			c.WriteReply(StatusBadArguments, "Syntax error.")
+		}
+		return
+	}
+
+	// https://tools.ietf.org/html/rfc2577
+	// Protecting Against the Bounce Attack
+	if addr.Port < 1024 || addr.Port > 65535 {
+		c.WriteReply(StatusNotImplemented, "Command not implemented for that parameter.")
+		return
+	}
+
+	_, err = c.newActiveDataTransfer(ctx, addr.String())
+	if err != nil {
+		c.server.logger().Printf(c.getSessionID(), "fail to enter active mode: %v", err)
+		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
+		return
+	}
+	c.WriteReply(StatusCommandOK, "Okay.")
+}
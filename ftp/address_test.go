@@ -0,0 +1,67 @@
+package ftp
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(string) (*net.TCPAddr, error)
+		arg   string
+		want  string
+		err   error
+	}{
+		{"PORT", parsePortAddress, "192,168,0,1,4,1", "192.168.0.1:1025", nil},
+		{"PORT", parsePortAddress, "192, 168, 0, 1, 4, 1", "192.168.0.1:1025", nil},
+		{"PORT", parsePortAddress, "192,168,0,1,4", "", errAddressSyntax},
+		{"PORT", parsePortAddress, "192,168,0,256,4,1", "", errAddressSyntax},
+		{"PORT", parsePortAddress, "a,b,c,d,e,f", "", errAddressSyntax},
+
+		{"EPRT", parseEprtAddress, "|1|192.168.0.1|1025|", "192.168.0.1:1025", nil},
+		{"EPRT", parseEprtAddress, "|2|::1|1025|", "[::1]:1025", nil},
+		{"EPRT", parseEprtAddress, "!1!192.168.0.1!1025!", "192.168.0.1:1025", nil},
+		{"EPRT", parseEprtAddress, "|1|::1|1025|", "", errInvalidAddress},
+		{"EPRT", parseEprtAddress, "|1|192.168.0.1|foo|", "", errInvalidPort},
+		{"EPRT", parseEprtAddress, "|3|192.168.0.1|1025|", "", unsupportedNetworkError("(1,2)")},
+		{"EPRT", parseEprtAddress, "|1|192.168.0.1|", "", errAddressSyntax},
+
+		{"LPRT", parseLprtAddress, "4,4,192,168,0,1,2,4,1", "192.168.0.1:1025", nil},
+		{"LPRT", parseLprtAddress, "6,16,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,2,4,1", "[::1]:1025", nil},
+		{"LPRT", parseLprtAddress, "4,4,192,168,0,1,1,255", "192.168.0.1:255", nil},
+		{"LPRT", parseLprtAddress, "4,16,192,168,0,1,2,4,1", "", errInvalidAddress},
+		{"LPRT", parseLprtAddress, "4,4,192,168,0,1", "", errInvalidAddress},
+		{"LPRT", parseLprtAddress, "4,4,192,168,0,1,2,4", "", errInvalidPort},
+		{"LPRT", parseLprtAddress, "4,4,192,168,0,1,3,0,4,1", "", errInvalidPort},
+		{"LPRT", parseLprtAddress, "5,4,192,168,0,1,2,4,1", "", unsupportedNetworkError("(4,6)")},
+		{"LPRT", parseLprtAddress, "4", "", errAddressSyntax},
+	}
+
+	for _, tt := range tests {
+		addr, err := tt.parse(tt.arg)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s %s: want error %v, got %v", tt.name, tt.arg, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error %v", tt.name, tt.arg, err)
+			continue
+		}
+		if addr.String() != tt.want {
+			t.Errorf("%s %s: want %s, got %s", tt.name, tt.arg, tt.want, addr.String())
+		}
+	}
+}
+
+func TestFormatLongAddress(t *testing.T) {
+	if got, want := formatLongAddress(net.ParseIP("192.168.0.1"), 1025), "4,4,192,168,0,1,2,4,1"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	if got, want := formatLongAddress(net.ParseIP("::1"), 1025), "6,16,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,2,4,1"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,88 @@
+package ftp
+
+import (
+	"bufio"
+	"io"
+)
+
+// asciiReader converts the line endings of ASCII type data from CRLF to LF.
+// https://tools.ietf.org/html/rfc959#section-3.1.1.1
+type asciiReader struct {
+	r *bufio.Reader
+
+	// the error that occurred while looking ahead of CR.
+	err error
+}
+
+func newASCIIReader(r io.Reader) *asciiReader {
+	return &asciiReader{r: bufio.NewReader(r)}
+}
+
+func (r *asciiReader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if r.r.Buffered() == 0 {
+			if n > 0 {
+				// return the data without blocking.
+				break
+			}
+			if r.err != nil {
+				return 0, r.err
+			}
+		}
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == '\r' {
+			next, err := r.r.Peek(1)
+			if err != nil {
+				r.err = err
+			} else if next[0] == '\n' {
+				continue
+			}
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// asciiWriter converts the line endings of ASCII type data from LF to CRLF.
+// LFs that already follow CR are sent unchanged, so CRLF files don't get extra CRs.
+type asciiWriter struct {
+	w   io.WriteCloser
+	buf []byte
+
+	// the last byte written was CR.
+	cr bool
+}
+
+func newASCIIWriter(w io.WriteCloser) *asciiWriter {
+	return &asciiWriter{w: w}
+}
+
+func (w *asciiWriter) Write(p []byte) (int, error) {
+	w.buf = w.buf[:0]
+	for _, b := range p {
+		if b == '\n' && !w.cr {
+			w.buf = append(w.buf, '\r')
+		}
+		w.buf = append(w.buf, b)
+		w.cr = b == '\r'
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying writer.
+func (w *asciiWriter) Close() error {
+	return w.w.Close()
+}
+
+// convertsASCII reports whether the data are converted for ASCII type.
+func (c *ServerConn) convertsASCII() bool {
+	return c.ascii && !c.server.ASCIIPassThrough
+}
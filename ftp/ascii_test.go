@@ -0,0 +1,77 @@
+package ftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestASCIIReader(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"foo\r\nbar\r\n", "foo\nbar\n"},
+		{"foo\nbar", "foo\nbar"},
+		{"foo\rbar\r", "foo\rbar\r"},
+		{"foo\r\r\n", "foo\r\n"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		// read one byte at a time, so CR and LF are in different reads.
+		r := newASCIIReader(iotest.OneByteReader(bytes.NewReader([]byte(c.input))))
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.want {
+			t.Errorf("%q: want %q, got %q", c.input, c.want, data)
+		}
+	}
+}
+
+func TestASCIIReader_Error(t *testing.T) {
+	r := newASCIIReader(io.MultiReader(
+		bytes.NewReader([]byte("foo\r")),
+		iotest.ErrReader(io.ErrUnexpectedEOF),
+	))
+	data, err := io.ReadAll(r)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("want error %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if string(data) != "foo\r" {
+		t.Errorf("want %q, got %q", "foo\r", data)
+	}
+}
+
+func TestASCIIWriter(t *testing.T) {
+	cases := []struct {
+		input []string
+		want  string
+	}{
+		{[]string{"foo\nbar\n"}, "foo\r\nbar\r\n"},
+		{[]string{"foo\r\nbar"}, "foo\r\nbar"},
+		{[]string{"foo\r", "\nbar\n"}, "foo\r\nbar\r\n"},
+		{[]string{"foo", "\n\n"}, "foo\r\n\r\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := newASCIIWriter(nopWriteCloser{&buf})
+		for _, s := range c.input {
+			n, err := io.WriteString(w, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(s) {
+				t.Errorf("want %d, got %d", len(s), n)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.want {
+			t.Errorf("%q: want %q, got %q", c.input, c.want, buf.String())
+		}
+	}
+}
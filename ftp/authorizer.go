@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/shogo82148/s3ftpgateway/vfs"
+	"golang.org/x/text/encoding"
 )
 
 // ErrAuthorizeFailed is an sentinel error for login failed.
@@ -19,6 +20,20 @@ type Authorizer interface {
 type Authorization struct {
 	User       string
 	FileSystem vfs.FileSystem
+
+	// Charset is the charset of the path names that the user's client sends, e.g. Shift_JIS.
+	// If it is nil, the charset of the listener is used (see CharsetContextKey).
+	Charset encoding.Encoding
+
+	// SiteCommands are the names of the subcommands of the SITE command that the user can execute.
+	// If it is nil, the user can execute all subcommands except WHO,
+	// which shows the user names and the IP addresses of all sessions.
+	// WHO is permitted only if it is listed explicitly.
+	SiteCommands []string
+
+	// Quota is the storage usage of the user, shown in the login message.
+	// It is optional, and nil means the Authorizer doesn't know it.
+	Quota *Quota
 }
 
 // AnonymousAuthorizer is an Authorizer for anonymous users.
@@ -32,8 +47,9 @@ func (anonymousAuthorizer) Authorize(ctx context.Context, conn *ServerConn, user
 		return nil, ErrAuthorizeFailed
 	}
 	return &Authorization{
-		User:       user,
-		FileSystem: vfs.ReadOnly(conn.Server().FileSystem),
+		User:         user,
+		FileSystem:   vfs.ReadOnly(conn.Server().ResolveHost(conn).FileSystem),
+		SiteCommands: []string{"HELP", "IDLE"},
 	}, nil
 }
 
@@ -0,0 +1,161 @@
+package ftp
+
+// catalogJa is the catalog of the reply messages in Japanese.
+var catalogJa = Catalog{
+	// greetings and authentication
+	"Service ready":                                      "サービスの準備ができました",
+	"Service ready for new user.":                        "新しいユーザーを受け付けます。",
+	"User name ok, password required.":                   "ユーザー名を確認しました。パスワードを入力してください。",
+	"User logged in, proceed.":                           "ログインしました。",
+	"Not logged in":                                      "ログインしていません",
+	"Not logged in.":                                     "ログインしていません。",
+	"Good bye.":                                          "さようなら。",
+	"Unknown host.":                                      "不明なホストです。",
+	"HOST must be sent before USER.":                     "HOST は USER より前に送信してください。",
+	"The host doesn't match the server name of TLS.":     "ホスト名が TLS のサーバー名と一致しません。",
+	"Idle timeout, closing control connection.":          "一定時間操作がなかったため、制御コネクションを切断します。",
+	"Service not available, closing control connection.": "サービスを利用できません。制御コネクションを切断します。",
+
+	// security extensions
+	"AUTH command OK.":                                  "AUTH コマンドが成功しました。",
+	"CCC command is disabled.":                          "CCC コマンドは無効です。",
+	"Clearing the command channel.":                     "コマンドチャンネルの暗号化を解除します。",
+	"The command channel is not protected by AUTH TLS.": "コマンドチャンネルは AUTH TLS で保護されていません。",
+	"Safe level is not supported.":                      "Safe レベルには対応していません。",
+	"Confidential level is not supported.":              "Confidential レベルには対応していません。",
+	"Private level is only supported in TLS.":           "Private レベルは TLS でのみ利用できます。",
+	"Permission was already granted.":                   "すでに許可されています。",
+
+	// common errors
+	"Command not found.":                          "コマンドが見つかりません。",
+	"Command not implemented for that parameter.": "そのパラメーターには対応していません。",
+	"Action aborted, required param missing.":     "必要なパラメーターがありません。",
+	"Action not taken.":                           "操作は実行されませんでした。",
+	"Requested action not taken.":                 "要求された操作は実行されませんでした。",
+	"Internal error.":                             "内部エラーが発生しました。",
+	"Syntax error.":                               "構文エラーです。",
+	"Syntax error in parameter.":                  "パラメーターの構文エラーです。",
+	"Syntax error in parameters or arguments.":    "パラメーターまたは引数の構文エラーです。",
+	"Invalid arguments.":                          "引数が不正です。",
+	"Invalid character encoding.":                 "文字コードが不正です。",
+	"Unknown command %s.":                         "不明なコマンド %s です。",
+	"%s.":                                         "%s。",
+	"Obsolete.":                                   "廃止されたコマンドです。",
+	"OK.":                                         "OK。",
+	"Okay.":                                       "OK。",
+
+	// files and directories
+	"No such file.":                                              "ファイルが存在しません。",
+	"No such directory.":                                         "ディレクトリが存在しません。",
+	"No such file or directory.":                                 "ファイルまたはディレクトリが存在しません。",
+	"Not a directory.":                                           "ディレクトリではありません。",
+	"Not a plain file.":                                          "通常のファイルではありません。",
+	"Permission denied.":                                         "権限がありません。",
+	"Permission is denied.":                                      "権限がありません。",
+	"Not supported by the file system.":                          "ファイルシステムが対応していません。",
+	"Exceeded storage allocation.":                               "容量の上限を超えました。",
+	"Directory changed to %s.":                                   "ディレクトリを %s に変更しました。",
+	"Removed directory %s":                                       "ディレクトリ %s を削除しました",
+	`"%s" directory created.`:                                    `"%s" ディレクトリを作成しました。`,
+	`"%s" directory already exists; taking no action.`:           `"%s" ディレクトリはすでに存在します。何もしません。`,
+	"Requested file action okay, completed.":                     "要求されたファイル操作が完了しました。",
+	"Requested file action pending further information.":         "要求されたファイル操作には追加の情報が必要です。",
+	"Requested file action aborted.":                             "要求されたファイル操作を中止しました。",
+	"RNTO must be call after RNFR.":                              "RNTO は RNFR の後に送信してください。",
+	"Renaming directories is not supported.":                     "ディレクトリの名前変更には対応していません。",
+	"The file was changed by another session.":                   "ファイルが別のセッションで変更されました。",
+	"The file already exists or was changed by another session.": "ファイルがすでに存在するか、別のセッションで変更されました。",
+	"SIZE not allowed in ASCII mode.":                            "ASCII モードでは SIZE を利用できません。",
+
+	// data transfers
+	"File status okay; about to open data connection.":               "データコネクションを開きます。",
+	"Data transfer starting":                                         "データ転送を開始します",
+	"Data transfer starting %s":                                      "データ転送を開始します %s",
+	"Data transfer starting: %s":                                     "データ転送を開始します: %s",
+	"OK, received %s.":                                               "%s を受信しました。",
+	"OK, received %s. unique file name: %s":                          "%s を受信しました。ファイル名: %s",
+	"Data connection failed.":                                        "データコネクションに失敗しました。",
+	"Connection closed; transfer aborted.":                           "コネクションが閉じられたため、転送を中止しました。",
+	"ABOR command successful.":                                       "ABOR コマンドが成功しました。",
+	"Restarting at %d. Send RETR to initiate transfer.":              "%d から再開します。RETR を送信して転送を開始してください。",
+	"Restarting at %d. Ending at %d.":                                "%d から %d まで転送します。",
+	"Restarting uploads is not supported.":                           "アップロードの再開には対応していません。",
+	"%s is only supported in binary type with stream or block mode.": "%s はバイナリタイプのストリームモードかブロックモードでのみ利用できます。",
+	"Invalid restart marker.":                                        "再開マーカーが不正です。",
+	"Invalid byte range.":                                            "バイト範囲が不正です。",
+	"Resetting byte range.":                                          "バイト範囲をリセットしました。",
+	"RANG can't be used with REST.":                                  "RANG と REST は同時に利用できません。",
+	"Syntax: RANG <start> <end>":                                     "構文: RANG <start> <end>",
+
+	// data connections
+	"%s command is disabled.":                "%s コマンドは無効です。",
+	"LPSV command is disabled.":              "LPSV コマンドは無効です。",
+	"PASV command is disabled.":              "PASV コマンドは無効です。",
+	"Passive mode is disabled.":              "パッシブモードは無効です。",
+	"Invalid address.":                       "アドレスが不正です。",
+	"Invalid port number.":                   "ポート番号が不正です。",
+	"Network protocol not supported, use %s": "対応していないネットワークプロトコルです。%s を利用してください",
+	"all data connection setup commands other than EPSV is disabled.": "EPSV 以外のデータコネクションを設定するコマンドは無効です。",
+
+	// transfer parameters
+	"Type set to ASCII.":               "転送タイプを ASCII に設定しました。",
+	"Type set to binary.":              "転送タイプをバイナリに設定しました。",
+	"Unknown type.":                    "不明な転送タイプです。",
+	"Change transfer mode to stream.":  "転送モードをストリームに変更しました。",
+	"Change transfer mode to block.":   "転送モードをブロックに変更しました。",
+	"Change transfer mode to deflate.": "転送モードを deflate に変更しました。",
+	"Unknown transfer mode.":           "不明な転送モードです。",
+	"Invalid mode.":                    "モードが不正です。",
+	"MODE Z LEVEL set to %d.":          "MODE Z の圧縮レベルを %d に設定しました。",
+	"Invalid compression level.":       "圧縮レベルが不正です。",
+	"Set file structure to file.":      "ファイル構造をファイルに設定しました。",
+	"Unknown file structure.":          "不明なファイル構造です。",
+
+	// options and features
+	"UTF8 mode enabled.":            "UTF8 モードを有効にしました。",
+	"UTF8 mode disabled.":           "UTF8 モードを無効にしました。",
+	"Unsupported non-utf8 mode.":    "UTF-8 以外のモードには対応していません。",
+	"Invalid option.":               "オプションが不正です。",
+	"Unknown algorithm.":            "不明なアルゴリズムです。",
+	"Language change to %s":         "言語を %s に変更しました",
+	"Language %s is not supported.": "言語 %s には対応していません。",
+	"Extensions supported:":         "対応している拡張機能:",
+	"End.":                          "終了。",
+
+	// facts and times
+	"Listing %s":                     "%s の情報",
+	"No facts.":                      "ファクトがありません。",
+	"Invalid fact.":                  "ファクトが不正です。",
+	"Unsupported fact %s.":           "ファクト %s には対応していません。",
+	"Invalid value of %s.":           "%s の値が不正です。",
+	"Invalid time.":                  "時刻が不正です。",
+	"Syntax: MFMT <time-val> <path>": "構文: MFMT <time-val> <path>",
+	"Syntax: MFCT <time-val> <path>": "構文: MFCT <time-val> <path>",
+	"Syntax: MFF <facts> <path>":     "構文: MFF <facts> <path>",
+
+	// listings and status
+	"Invalid pattern.":                                      "パターンが不正です。",
+	"Listing truncated after %d entries; %s":                "%d 件で一覧を打ち切りました。%s",
+	"Listing truncated after %d entries; use LIST command.": "%d 件で一覧を打ち切りました。LIST コマンドを利用してください。",
+	"Status of %s:":                                         "%s の状態:",
+	"End of status.":                                        "状態の終わり。",
+	"s3ftpgateway status:":                                  "s3ftpgateway の状態:",
+
+	// SITE commands
+	"Unknown SITE command %s.":                    "不明な SITE コマンド %s です。",
+	"The following SITE commands are recognized:": "以下の SITE コマンドを利用できます:",
+	"Help OK.":                                               "ヘルプの終わり。",
+	"SITE CHMOD command successful.":                         "SITE CHMOD コマンドが成功しました。",
+	"Syntax: SITE CHMOD <mode> <path>":                       "構文: SITE CHMOD <mode> <path>",
+	"SITE UTIME command successful.":                         "SITE UTIME コマンドが成功しました。",
+	"Syntax: SITE UTIME <YYYYMMDDhhmm[ss]> <path>":           "構文: SITE UTIME <YYYYMMDDhhmm[ss]> <path>",
+	"%d active sessions:":                                    "%d 件のセッション:",
+	"End of list.":                                           "一覧の終わり。",
+	"No idle time limit.":                                    "アイドルタイムアウトはありません。",
+	"No idle time limit; max %d seconds.":                    "アイドルタイムアウトはありません。最大 %d 秒です。",
+	"Current idle time limit is %d seconds.":                 "現在のアイドルタイムアウトは %d 秒です。",
+	"Current idle time limit is %d seconds; max %d seconds.": "現在のアイドルタイムアウトは %d 秒です。最大 %d 秒です。",
+	"The idle time limit must be between 1 and %d seconds.":  "アイドルタイムアウトは 1 から %d 秒の間で指定してください。",
+	"Invalid idle time limit.":                               "アイドルタイムアウトが不正です。",
+	"Maximum idle time set to %d seconds.":                   "アイドルタイムアウトを %d 秒に設定しました。",
+}
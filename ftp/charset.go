@@ -0,0 +1,97 @@
+package ftp
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+)
+
+// CharsetContextKey is a context key.
+// It can be used in BaseContext to set the charset of the control connections that the listener accepts,
+// for legacy clients that send non-UTF-8 path names, e.g. Shift_JIS.
+// The associated value will be of type encoding.Encoding.
+// The charset of the user, Authorization.Charset, has priority over it.
+var CharsetContextKey = &contextKey{"charset"}
+
+// errInvalidEncoding is returned when the command isn't encoded in the charset of the control connection.
+var errInvalidEncoding = errors.New("ftp: invalid character encoding")
+
+// utf8Option is the state of OPTS UTF8 command.
+type utf8Option int
+
+const (
+	// the client doesn't send OPTS UTF8. the charset of the user or the listener is used.
+	utf8Default utf8Option = iota
+
+	// OPTS UTF8 ON
+	utf8On
+
+	// OPTS UTF8 OFF
+	utf8Off
+)
+
+// legacyCharset returns the non-UTF-8 charset configured for the user or the listener.
+// It returns nil if there is no such charset.
+func (c *ServerConn) legacyCharset() encoding.Encoding {
+	if c.auth != nil && c.auth.Charset != nil {
+		return c.auth.Charset
+	}
+	charset, _ := c.ctx.Value(CharsetContextKey).(encoding.Encoding)
+	return charset
+}
+
+// updateCharset updates the charset of the control connection.
+// It must be called when the user or the option of UTF8 is changed.
+func (c *ServerConn) updateCharset() {
+	var charset encoding.Encoding
+	if c.utf8 != utf8On {
+		charset = c.legacyCharset()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.charset = charset
+}
+
+// decodeCommand converts the command line in the charset of the control connection into UTF-8.
+func (c *ServerConn) decodeCommand(line string) (string, error) {
+	if c.charset == nil {
+		return line, nil
+	}
+	s, err := c.charset.NewDecoder().String(line)
+	if err != nil {
+		return "", errInvalidEncoding
+	}
+	if strings.ContainsRune(s, utf8.RuneError) {
+		// the decoder replaces invalid byte sequences with U+FFFD.
+		// reject them rather than creating the files that have broken names.
+		return "", errInvalidEncoding
+	}
+	return s, nil
+}
+
+// encodeString converts s into the charset.
+// The characters that the charset can't represent are replaced with '?'.
+// It returns s as is if charset is nil.
+func encodeString(charset encoding.Encoding, s string) string {
+	if charset == nil {
+		return s
+	}
+	enc := charset.NewEncoder()
+	if ret, err := enc.String(s); err == nil {
+		return ret
+	}
+
+	// slow path: encode the characters one by one.
+	var buf strings.Builder
+	for _, r := range s {
+		b, err := enc.String(string(r))
+		if err != nil {
+			b = "?"
+		}
+		buf.WriteString(b)
+	}
+	return buf.String()
+}
@@ -0,0 +1,44 @@
+package ftp
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestEncodeString(t *testing.T) {
+	cases := []struct {
+		charset encoding.Encoding
+		in      string
+		want    string
+	}{
+		{nil, "日本語.txt", "日本語.txt"},
+		{japanese.ShiftJIS, "日本語.txt", "\x93\xfa\x96\x7b\x8c\xea.txt"},
+		{japanese.EUCJP, "日本語.txt", "\xc6\xfc\xcb\xdc\xb8\xec.txt"},
+
+		// the characters that Shift_JIS can't represent.
+		{japanese.ShiftJIS, "日本😀.txt", "\x93\xfa\x96\x7b?.txt"},
+	}
+	for _, tc := range cases {
+		if got := encodeString(tc.charset, tc.in); got != tc.want {
+			t.Errorf("encodeString(%v, %q): want %q, got %q", tc.charset, tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	c := &ServerConn{charset: japanese.ShiftJIS}
+	got, err := c.decodeCommand("RETR \x93\xfa\x96\x7b\x8c\xea.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "RETR 日本語.txt"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// invalid byte sequence
+	if _, err := c.decodeCommand("RETR \x93.txt"); err != errInvalidEncoding {
+		t.Errorf("want errInvalidEncoding, got %v", err)
+	}
+}
@@ -2,19 +2,26 @@ package ftp
 
 import (
 	"bufio"
+	"compress/zlib"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
 	pkgpath "path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/shogo82148/s3ftpgateway/vfs"
 )
 
 // Command is a ftp command.
@@ -56,10 +63,52 @@ func handleFileError(c *ServerConn, err error) {
 		c.WriteReply(StatusFileUnavailable, "Permission is denied.")
 		return
 	}
-	c.server.logger().Printf(c.sessionID, "fail to open file: %v", err)
+	c.server.logger().Printf(c.getSessionID(), "fail to open file: %v", err)
 	c.WriteReply(StatusBadCommand, "Internal error.")
 }
 
+// helper function for handling errors of storing files (e.g. Create)
+func handleStoreError(c *ServerConn, err error) {
+	c.server.logger().Printf(c.getSessionID(), "fail to store file: %v", err)
+	if errors.Is(err, vfs.ErrFileTooLarge) {
+		c.WriteReply(StatusExceededStorage, "Exceeded storage allocation.")
+		return
+	}
+	if errors.Is(err, errMissingEOF) {
+		c.WriteReply(StatusTransfertAborted, "Connection closed; transfer aborted.")
+		return
+	}
+	if errors.Is(err, vfs.ErrConflict) {
+		c.WriteReply(StatusFileUnavailable, "The file already exists or was changed by another session.")
+		return
+	}
+	c.WriteReply(StatusActionAborted, "Requested file action aborted.")
+}
+
+// helper function for handling errors of the aborted data transfers.
+// It reports whether the transfer is aborted by ABOR command.
+func handleAbort(ctx context.Context, c *ServerConn) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	c.server.logger().Print(c.getSessionID(), "the data transfer is aborted")
+	c.WriteReply(StatusTransfertAborted, "Connection closed; transfer aborted.")
+	return true
+}
+
+// helper function for handling errors of changing the attributes of files.
+func handleAttrError(c *ServerConn, err error) {
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.WriteReply(StatusNotImplementedParameter, "Not supported by the file system.")
+		return
+	}
+	if errors.Is(err, vfs.ErrConflict) {
+		c.WriteReply(StatusFileUnavailable, "The file was changed by another session.")
+		return
+	}
+	handleFileError(c, err)
+}
+
 type command interface {
 	IsExtend() bool
 	RequireParam() bool
@@ -67,10 +116,17 @@ type command interface {
 	Execute(ctx context.Context, c *ServerConn, cmd *Command)
 }
 
+// featureParam is implemented by commands that have feature parameters in FEAT.
+// The commands of RFC 959 that implement it are also listed in FEAT, e.g. MODE Z.
 type featureParam interface {
 	FeatureParam() string
 }
 
+// connFeatureParam is implemented by commands whose feature parameters depend on the connection.
+type connFeatureParam interface {
+	ConnFeatureParam(c *ServerConn) string
+}
+
 var commands = map[string]command{
 	// FILE TRANSFER PROTOCOL (FTP)
 	// https://tools.ietf.org/html/rfc959
@@ -92,12 +148,12 @@ var commands = map[string]command{
 	"PORT": commandPort{},
 	"PWD":  commandPwd{},
 	"QUIT": commandQuit{},
-	// "REIN": nil, // a number of FTP servers do not implement it.
+	"REIN": commandRein{},
 	"RETR": commandRetr{},
 	"RMD":  commandRmd{},
 	"RNFR": commandRnfr{},
 	"RNTO": commandRnto{},
-	"SITE": nil,
+	"SITE": commandSite{},
 	// "SMNT": nil, // mount is not permitted.
 	"STAT": commandStat{},
 	"STOR": commandStor{},
@@ -110,14 +166,14 @@ var commands = map[string]command{
 	// FTP Operation Over Big Address Records (FOOBAR)
 	// https://tools.ietf.org/html/rfc1639
 	// These commands are obsoleted by https://tools.ietf.org/html/rfc5797
-	// "LPRT": commandLprt{},
-	// "LPSV": commandLpsv{},
+	"LPRT": commandLprt{},
+	"LPSV": commandLpsv{},
 
 	// FTP Security Extensions
 	// https://tools.ietf.org/html/rfc2228
 	"ADAT": nil,
 	"AUTH": commandAuth{},
-	"CCC":  nil,
+	"CCC":  commandCcc{},
 	"CONF": nil,
 	"ENC":  nil,
 	"MIC":  nil,
@@ -143,9 +199,34 @@ var commands = map[string]command{
 	"MDTM": commandMdtm{},
 	"MLSD": commandMlsd{},
 	"MLST": commandMlst{},
-	// "REST": nil, // I gave up, see https://github.com/shogo82148/s3ftpgateway/pull/34
+	"REST": commandRest{},
 	"SIZE": commandSize{},
 
+	// File Transfer Protocol HOST Command for Virtual Hosts
+	// https://tools.ietf.org/html/rfc7151
+	"HOST": commandHost{},
+
+	// File Transfer Protocol HASH Command for Cryptographic Hashes
+	// https://tools.ietf.org/html/draft-bryan-ftpext-hash-02
+	"HASH": commandHash{},
+
+	// File Transfer Protocol RANG Command for Octet Ranges
+	// https://tools.ietf.org/html/draft-bryan-ftp-range-08
+	"RANG": commandRang{},
+
+	// The "MFMT", "MFCT", and "MFF" Command Extensions for FTP
+	// https://tools.ietf.org/html/draft-somers-ftp-mfxx-04
+	"MFCT": commandMfct{},
+	"MFF":  commandMff{},
+	"MFMT": commandMfmt{},
+
+	// Legacy commands for file checksums.
+	// https://tools.ietf.org/html/draft-bryan-ftpext-hash-02#appendix-B
+	"XCRC":    commandXhash{vfs.HashCRC32},
+	"XMD5":    commandXhash{vfs.HashMD5},
+	"XSHA1":   commandXhash{vfs.HashSHA1},
+	"XSHA256": commandXhash{vfs.HashSHA256},
+
 	// HTTP methods.
 	"GET":     commandReject{},
 	"HEAD":    commandReject{},
@@ -158,14 +239,22 @@ var commands = map[string]command{
 	"PATCH":   commandReject{},
 }
 
+// ABORT (ABOR)
+// This command tells the server to abort the previous FTP
+// service command and any associated transfer of data.
 type commandAbor struct{}
 
 func (commandAbor) IsExtend() bool     { return false }
-func (commandAbor) RequireParam() bool { return true }
+func (commandAbor) RequireParam() bool { return false }
 func (commandAbor) RequireAuth() bool  { return true }
 
 func (commandAbor) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	// cancel the operation of the file system, e.g. the multipart upload, and close the data connection.
+	// the aborted transfer replies 426 before the reply of ABOR.
+	c.abortTransfer()
 	c.closeDataTransfer()
+	c.transfers.Wait()
+	c.WriteReply(StatusClosingDataConnection, "ABOR command successful.")
 }
 
 // ACCOUNT (ACCT)
@@ -208,12 +297,17 @@ func (commandAppe) RequireParam() bool { return true }
 func (commandAppe) RequireAuth() bool  { return true }
 
 func (commandAppe) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	tctx, cancel := context.WithCancel(context.Background())
+	if c.restart > 0 || c.byteRange != nil {
+		c.WriteReply(StatusInvalidRestParameter, "Restarting uploads is not supported.")
+		return
+	}
+	tctx, cancel := c.newTransferContext()
 
 	name := c.buildPath(cmd.Arg)
 	fs := c.fileSystem()
 	chSuccess := make(chan bool, 1)
-	go func() {
+	format := c.dataFormat()
+	c.startTransfer(func() {
 		defer cancel()
 		r, err := fs.Open(tctx, name)
 		if err != nil {
@@ -225,7 +319,7 @@ func (commandAppe) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 				return
 			} else {
 				chSuccess <- false
-				c.server.logger().Printf(c.sessionID, "fail to open file: %v", err)
+				c.server.logger().Printf(c.getSessionID(), "fail to open file: %v", err)
 				c.WriteReply(StatusBadCommand, "Internal error.")
 				return
 			}
@@ -236,23 +330,27 @@ func (commandAppe) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		conn, err := c.dt.Conn(tctx)
 		if err != nil {
 			chSuccess <- false
-			c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
+			c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
 			c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
 			return
 		}
 		defer c.closeDataTransfer()
 
 		chSuccess <- true
-		cr := &countReader{Reader: conn}
+		wire := &countReader{Reader: conn}
+		cr := &countReader{Reader: format.reader(wire)}
 		reader := io.MultiReader(r, cr)
 		err = c.fileSystem().Create(tctx, name, reader)
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to store file: %v", err)
-			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
+			handleStoreError(c, err)
 			return
 		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("OK, received %d bytes.", cr.count))
-	}()
+		c.bytesReceived.Add(cr.count)
+		c.WriteReplyf(StatusClosingDataConnection, "OK, received %s.", byteCounts(cr.count, wire.count))
+	})
 	select {
 	case success := <-chSuccess:
 		if !success {
@@ -280,7 +378,7 @@ func (commandCdup) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		return
 	}
 	c.pwd = pkgpath.Dir(c.pwd)
-	c.WriteReply(StatusCommandOK, fmt.Sprintf("Directory changed to %s.", c.pwd))
+	c.writeDirChanged(ctx)
 }
 
 type commandCwd struct{}
@@ -301,7 +399,13 @@ func (commandCwd) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		return
 	}
 	c.pwd = path
-	c.WriteReply(StatusCommandOK, fmt.Sprintf("Directory changed to %s.", path))
+	c.writeDirChanged(ctx)
+}
+
+// writeDirChanged replies to CWD and CDUP commands, with the directory message.
+func (c *ServerConn) writeDirChanged(ctx context.Context) {
+	msg := fmt.Sprintf(c.message("Directory changed to %s."), c.pwd)
+	c.WriteReply(StatusCommandOK, append(c.dirMessage(ctx, c.pwd), msg)...)
 }
 
 // DELETE (DELE)
@@ -323,7 +427,7 @@ func (commandDele) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		c.WriteReply(StatusBadCommand, "Internal error.")
 		return
 	}
-	c.WriteReply(StatusCommandOK, "Removed directory "+path)
+	c.WriteReplyf(StatusCommandOK, "Removed directory %s", path)
 }
 
 // HELP (HELP)
@@ -344,10 +448,10 @@ func (commandHelp) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	name := strings.ToUpper(cmd.Arg)
 	command, ok := commands[name]
 	if !ok || command == nil {
-		c.WriteReply(StatusNotImplemented, fmt.Sprintf("Unknown command %s.", name))
+		c.WriteReplyf(StatusNotImplemented, "Unknown command %s.", name)
 		return
 	}
-	c.WriteReply(StatusHelp, fmt.Sprintf("%s.", name))
+	c.WriteReplyf(StatusHelp, "%s.", name)
 }
 
 // LIST (LIST)
@@ -358,49 +462,7 @@ func (commandList) RequireParam() bool { return false }
 func (commandList) RequireAuth() bool  { return true }
 
 func (commandList) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	path := c.pwd
-
-	// RFC959 says the argument is a path name, but some ftp clients send options(e.g. '-al').
-	// it is hard to parse them, so we just ignore them.
-	if cmd.Arg != "" && cmd.Arg[0] != '-' {
-		path = c.buildPath(cmd.Arg)
-	}
-
-	info, err := c.fileSystem().ReadDir(ctx, path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			c.WriteReply(StatusNeedSomeUnavailableResource, "No such directory.")
-			return
-		}
-		c.WriteReply(StatusBadCommand, "Internal error.")
-		return
-	}
-	c.WriteReply(StatusAboutToSend, "File status okay; about to open data connection.")
-
-	conn, err := c.dt.Conn(ctx)
-	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
-		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
-		return
-	}
-
-	go func() {
-		defer c.closeDataTransfer()
-		w := bufio.NewWriter(conn)
-		bytes := int64(0)
-		for _, fi := range info {
-			n, _ := io.WriteString(w, c.formatFileInfo(fi))
-			bytes += int64(n)
-			n, _ = io.WriteString(w, "\r\n")
-			bytes += int64(n)
-		}
-		if err := w.Flush(); err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to list directory: %v", err)
-			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
-			return
-		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("Data transfer starting %d bytes", bytes))
-	}()
+	executeList(ctx, c, cmd, true)
 }
 
 type commandMkd struct{}
@@ -413,16 +475,13 @@ func (commandMkd) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	path := c.buildPath(cmd.Arg)
 	if err := c.fileSystem().Mkdir(ctx, path); err != nil {
 		if os.IsExist(err) {
-			c.WriteReply(
-				StatusDirectoryAlreadyExists,
-				fmt.Sprintf(`"%s" directory already exists; taking no action.`, escapeQuote.Replace(path)),
-			)
+			c.WriteReplyf(StatusDirectoryAlreadyExists, `"%s" directory already exists; taking no action.`, escapeQuote.Replace(path))
 			return
 		}
 		c.WriteReply(StatusBadCommand, "Internal error.")
 		return
 	}
-	c.WriteReply(StatusPathCreated, fmt.Sprintf(`"%s" directory created.`, escapeQuote.Replace(path)))
+	c.WriteReplyf(StatusPathCreated, `"%s" directory created.`, escapeQuote.Replace(path))
 }
 
 // NAME LIST (NLST)
@@ -435,44 +494,7 @@ func (commandNlst) RequireParam() bool { return false }
 func (commandNlst) RequireAuth() bool  { return true }
 
 func (commandNlst) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	path := c.pwd
-	if cmd.Arg != "" {
-		path = c.buildPath(cmd.Arg)
-	}
-	info, err := c.fileSystem().ReadDir(ctx, path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			c.WriteReply(StatusNeedSomeUnavailableResource, "No such directory.")
-			return
-		}
-		c.WriteReply(StatusBadCommand, "Internal error.")
-		return
-	}
-	c.WriteReply(StatusAboutToSend, "File status okay; about to open data connection.")
-
-	conn, err := c.dt.Conn(ctx)
-	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
-		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
-		return
-	}
-
-	go func() {
-		defer c.closeDataTransfer()
-		w := bufio.NewWriter(conn)
-		bytes := int64(0)
-		for _, fi := range info {
-			n, _ := fmt.Fprintf(w, "%s\r\n", fi.Name())
-			bytes += int64(n)
-		}
-		if err := w.Flush(); err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to list directory: %v", err)
-			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
-			return
-		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("Data transfer starting %d bytes", bytes))
-	}()
-
+	executeList(ctx, c, cmd, false)
 }
 
 // NOOP (NOOP)
@@ -498,13 +520,24 @@ func (commandMode) IsExtend() bool     { return false }
 func (commandMode) RequireParam() bool { return true }
 func (commandMode) RequireAuth() bool  { return true }
 
+// FeatureParam returns the mode that extends RFC 959, the deflate mode.
+func (commandMode) FeatureParam() string { return "Z" }
+
 func (commandMode) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	switch cmd.Arg {
 	case "S", "s": // Stream Mode
+		c.mode = transferModeStream
 		c.WriteReply(StatusCommandOK, "Change transfer mode to stream.")
 		return
-		// RFC 959 assigns the following modes, but they are obsolete.
-		// case "B", "b": // Block Mode
+	case "B", "b": // Block Mode
+		c.mode = transferModeBlock
+		c.WriteReply(StatusCommandOK, "Change transfer mode to block.")
+		return
+	case "Z", "z": // Deflate Mode
+		c.mode = transferModeDeflate
+		c.WriteReply(StatusCommandOK, "Change transfer mode to deflate.")
+		return
+		// RFC 959 assigns the following mode, but it is obsolete.
 		// case "C", "c": // Compressed Mode
 	}
 	c.WriteReply(StatusNotImplementedParameter, "Unknown transfer mode.")
@@ -517,12 +550,12 @@ func (commandPass) RequireParam() bool { return true }
 func (commandPass) RequireAuth() bool  { return false }
 
 func (commandPass) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	auth, err := c.server.authorizer().Authorize(ctx, c, c.user, cmd.Arg)
+	auth, err := c.server.ResolveHost(c).Authorizer.Authorize(ctx, c, c.user, cmd.Arg)
 	if err != nil {
 		c.failCnt++
 		if c.failCnt > 1 || !isAnonymous(c.user) {
 			if err := sleepWithContext(ctx, 5*time.Second); err != nil {
-				c.server.logger().Printf(c.sessionID, "fail to execute PASS command: %v", err)
+				c.server.logger().Printf(c.getSessionID(), "fail to execute PASS command: %v", err)
 				c.shuttingDown.setTrue()
 				return
 			}
@@ -543,7 +576,8 @@ func (commandPass) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	c.failCnt = 0
 	c.auth = auth
 	c.pwd = "/"
-	c.WriteReply(StatusLoggedIn, "User logged in, proceed.")
+	c.updateCharset()
+	c.WriteReply(StatusLoggedIn, append(c.loginMessage(), "User logged in, proceed.")...)
 }
 
 func isAnonymous(user string) bool {
@@ -582,7 +616,7 @@ func (commandPasv) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 			c.WriteReply(StatusNotImplemented, "Passive mode is disabled.")
 			return
 		}
-		c.server.logger().Printf(c.sessionID, "fail to enter passive mode: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to enter passive mode: %v", err)
 		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
 		return
 	}
@@ -597,41 +631,7 @@ func (commandPort) RequireParam() bool { return true }
 func (commandPort) RequireAuth() bool  { return true }
 
 func (commandPort) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	if c.epsvAll || !c.server.EnableActiveMode {
-		c.WriteReply(StatusBadArguments, "PORT command is disabled.")
-		return
-	}
-
-	args := strings.Split(cmd.Arg, ",")
-	if len(args) != 6 {
-		c.WriteReply(StatusBadArguments, "Syntax error.")
-		return
-	}
-	nums := make([]int, 0, 6)
-	for _, s := range args {
-		n, err := strconv.Atoi(strings.TrimSpace(s))
-		if err != nil {
-			c.WriteReply(StatusBadArguments, "Syntax error.")
-			return
-		}
-		nums = append(nums, n)
-	}
-	port := (nums[4] << 8) + nums[5]
-
-	// https://tools.ietf.org/html/rfc2577
-	// Protecting Against the Bounce Attack
-	if port < 1024 || port > 65535 {
-		c.WriteReply(StatusNotImplemented, "Command not implemented for that parameter.")
-		return
-	}
-
-	_, err := c.newActiveDataTransfer(ctx, fmt.Sprintf("%d.%d.%d.%d:%d", nums[0], nums[1], nums[2], nums[3], port))
-	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to enter active mode: %v", err)
-		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
-		return
-	}
-	c.WriteReply(StatusCommandOK, "Okay.")
+	executeActive(ctx, c, cmd, parsePortAddress)
 }
 
 type commandPwd struct{}
@@ -657,6 +657,20 @@ func (commandQuit) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	c.shuttingDown.setTrue()
 }
 
+// REINITIALIZE (REIN)
+// This command terminates a USER, flushing all I/O and account
+// information, except to allow any transfer in progress to be completed.
+type commandRein struct{}
+
+func (commandRein) IsExtend() bool     { return false }
+func (commandRein) RequireParam() bool { return false }
+func (commandRein) RequireAuth() bool  { return false }
+
+func (commandRein) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	c.reinitialize()
+	c.WriteReply(StatusReady, "Service ready for new user.")
+}
+
 // commandRetr causes the server-DTP to transfer a copy of the
 // file, specified in the pathname, to the server- or user-DTP
 // at the other end of the data connection.  The status and
@@ -669,15 +683,21 @@ func (commandRetr) RequireAuth() bool  { return true }
 
 func (commandRetr) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	// tctx is a context for transfering data
-	tctx, cancel := context.WithCancel(context.Background())
+	tctx, cancel := c.newTransferContext()
+	name := c.buildPath(cmd.Arg)
+	offset, limit := c.restart, int64(-1)
+	if r := c.byteRange; r != nil {
+		offset, limit = r.start, r.end-r.start+1
+	}
 
 	cherr := make(chan error, 1)
-	go func() {
+	format := c.dataFormat()
+	c.startTransfer(func() {
 		defer cancel()
 
-		f, err := c.fileSystem().Open(tctx, cmd.Arg)
+		f, err := vfs.OpenOffset(tctx, c.fileSystem(), name, offset)
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to retrieve file: %v", err)
+			c.server.logger().Printf(c.getSessionID(), "fail to retrieve file: %v", err)
 			cherr <- err
 			return
 		}
@@ -686,7 +706,7 @@ func (commandRetr) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		c.WriteReply(StatusAboutToSend, "File status okay; about to open data connection.")
 		conn, err := c.dt.Conn(tctx)
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
+			c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
 			cherr <- err
 			return
 		}
@@ -696,15 +716,28 @@ func (commandRetr) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		cherr <- nil
 
 		// transfering continues in the background.
-		n, err := io.Copy(conn, f)
+		wire := &countWriter{Writer: conn}
+		w := format.writer(wire, offset)
+		var r io.Reader = f
+		if limit >= 0 {
+			r = io.LimitReader(f, limit)
+		}
+		n, err := io.Copy(w, r)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to retrieve file: %v", err)
+			c.server.logger().Printf(c.getSessionID(), "fail to retrieve file: %v", err)
 			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
 			return
 		}
 
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("Data transfer starting %d bytes", n))
-	}()
+		c.bytesSent.Add(n)
+		c.WriteReplyf(StatusClosingDataConnection, "Data transfer starting %s", byteCounts(n, wire.count))
+	})
 
 	// wait for starting to transfer.
 	var err error
@@ -737,7 +770,7 @@ func (commandRmd) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 		c.WriteReply(StatusBadCommand, "Internal error.")
 		return
 	}
-	c.WriteReply(StatusCommandOK, "Removed directory "+path)
+	c.WriteReplyf(StatusCommandOK, "Removed directory %s", path)
 }
 
 // RRENAME FROM (RNFR)
@@ -750,24 +783,30 @@ func (commandRnfr) RequireParam() bool { return true }
 func (commandRnfr) RequireAuth() bool  { return true }
 
 func (commandRnfr) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	if c.rmfr != "" || c.rmfrReader != nil {
+	if c.rmfr != "" {
 		c.WriteReply(StatusBadSequence, "RNTO must be call after RNFR.")
 		return
 	}
 
 	path := c.buildPath(cmd.Arg)
-	r, err := c.fileSystem().Open(ctx, path)
+	stat, err := c.fileSystem().Stat(ctx, path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			c.WriteReply(StatusNeedSomeUnavailableResource, "No such directory.")
 			return
 		}
-		c.server.logger().Printf(c.sessionID, "fail to open file: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to stat file: %v", err)
 		c.WriteReply(StatusBadCommand, "Internal error.")
 		return
 	}
+	if stat.IsDir() {
+		c.WriteReply(StatusFileUnavailable, "Renaming directories is not supported.")
+		return
+	}
 	c.rmfr = path
-	c.rmfrReader = r
+	if c.server.RenameCompareAndSwap {
+		c.rmfrETag = vfs.ETag(stat)
+	}
 	c.WriteReply(StatusRequestFilePending, "Requested file action pending further information.")
 }
 
@@ -783,29 +822,33 @@ func (commandRnto) RequireAuth() bool  { return true }
 
 func (commandRnto) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	fs := c.fileSystem()
-	if c.rmfr == "" || c.rmfrReader == nil {
+	if c.rmfr == "" {
 		c.WriteReply(StatusBadSequence, "RNTO must be call after RNFR.")
 		return
 	}
 	from := c.rmfr
 	to := c.buildPath(cmd.Arg)
-	r := c.rmfrReader
+	etag := c.rmfrETag
 	c.rmfr = ""
-	c.rmfrReader = nil
+	c.rmfrETag = ""
 
-	go func() {
-		defer r.Close()
-		ctx, cancel := context.WithCancel(context.Background())
+	tctx, cancel := c.newTransferContext()
+	c.startTransfer(func() {
 		defer cancel()
-		err := fs.Create(ctx, to, r)
+		err := vfs.Rename(tctx, fs, from, to, etag)
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to create file: %v", err)
-			c.WriteReply(StatusBadCommand, "Internal error.")
+			if os.IsNotExist(err) {
+				c.WriteReply(StatusFileUnavailable, "No such file.")
+				return
+			}
+			handleStoreError(c, err)
 			return
 		}
-		fs.Remove(ctx, from)
 		c.WriteReply(StatusRequestedFileActionOK, "Requested file action okay, completed.")
-	}()
+	})
 }
 
 // STATUS (STAT)
@@ -819,22 +862,92 @@ func (commandStat) RequireAuth() bool  { return true }
 
 func (commandStat) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	if cmd.Arg == "" {
-		c.WriteReply(StatusSystem, "https://github.com/shogo82148/s3ftpgateway")
+		c.WriteReply(StatusSystem, c.sessionStatus()...)
 		return
 	}
 
-	fs := c.fileSystem()
-	path := c.buildPath(cmd.Arg)
-	stat, err := fs.Stat(ctx, path)
+	// the argument is same as LIST command, but the listing is sent over the control connection.
+	opts := parseListOptions(cmd.Arg)
+	opts.long = true
+	list, isDir, err := c.listTarget(ctx, opts)
 	if err != nil {
+		if errors.Is(err, pkgpath.ErrBadPattern) {
+			c.WriteReply(StatusBadArguments, "Invalid pattern.")
+			return
+		}
 		handleFileError(c, err)
 		return
 	}
+
+	var buf strings.Builder
+	l := &lister{c: c, opts: opts, w: &buf, maxBytes: maxStatSize, user: c.auth.User}
+	err = l.list(ctx, list)
+	truncated := errors.Is(err, errListTruncated)
+	if err != nil && !truncated {
+		c.server.logger().Printf(c.getSessionID(), "fail to list directory: %v", err)
+		c.WriteReply(StatusBadCommand, "Internal error.")
+		return
+	}
+
 	status := StatusFile
-	if stat.IsDir() {
+	if isDir {
 		status = StatusDirectory
 	}
-	c.WriteReply(status, "STAT", c.formatFileInfo(stat), "End.")
+	name := opts.path
+	if name == "" {
+		name = c.pwd
+	}
+	lines := []string{fmt.Sprintf(c.message("Status of %s:"), name)}
+	lines = append(lines, strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")...)
+	if truncated {
+		lines = append(lines, fmt.Sprintf(c.message("Listing truncated after %d entries; use LIST command."), l.entries))
+	}
+	lines = append(lines, "End of status.")
+	c.WriteReply(status, lines...)
+}
+
+// sessionStatus returns the status of the session for STAT command.
+func (c *ServerConn) sessionStatus() []string {
+	control, data := "plain", "clear"
+	if c.ccc {
+		control = "cleared by CCC"
+	} else if c.tls {
+		control = "TLS"
+	}
+	if c.prot == protectionLevelPrivate {
+		data = "private"
+	}
+	typ := "Image"
+	if c.ascii {
+		typ = "ASCII"
+	}
+	mode := "Stream"
+	switch c.mode {
+	case transferModeBlock:
+		mode = "Block"
+	case transferModeDeflate:
+		mode = "Deflate"
+	}
+	dt := "none"
+	c.mudt.Lock()
+	switch c.dt.(type) {
+	case *activeDataTransfer:
+		dt = "active"
+	case *passiveDataTransfer:
+		dt = "passive"
+	}
+	c.mudt.Unlock()
+
+	return []string{
+		"s3ftpgateway status:",
+		fmt.Sprintf(" Connected from %s", c.rwc.RemoteAddr()),
+		fmt.Sprintf(" Logged in as %s", c.auth.User),
+		fmt.Sprintf(" Control connection: %s, data connection protection: %s", control, data),
+		fmt.Sprintf(" TYPE: %s, MODE: %s, STRU: File", typ, mode),
+		fmt.Sprintf(" Data connection: %s", dt),
+		fmt.Sprintf(" %d bytes sent, %d bytes received", c.bytesSent.Load(), c.bytesReceived.Load()),
+		"End of status.",
+	}
 }
 
 // commandStor
@@ -845,27 +958,38 @@ func (commandStor) RequireParam() bool { return true }
 func (commandStor) RequireAuth() bool  { return true }
 
 func (commandStor) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if c.restart > 0 || c.byteRange != nil {
+		c.WriteReply(StatusInvalidRestParameter, "Restarting uploads is not supported.")
+		return
+	}
 	c.WriteReply(StatusAboutToSend, "Data transfer starting")
 
-	name := cmd.Arg
+	name := c.buildPath(cmd.Arg)
 	conn, err := c.dt.Conn(ctx)
 	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
 		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
 		return
 	}
 
-	go func() {
+	tctx, cancel := c.newTransferContext()
+	format := c.dataFormat()
+	c.startTransfer(func() {
+		defer cancel()
 		defer c.closeDataTransfer()
-		r := &countReader{Reader: conn}
-		err = c.fileSystem().Create(context.Background(), name, r)
+		wire := &countReader{Reader: conn}
+		r := &countReader{Reader: format.reader(wire)}
+		err = c.fileSystem().Create(tctx, name, r)
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to store file: %v", err)
-			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
+			handleStoreError(c, err)
 			return
 		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("OK, received %d bytes.", r.count))
-	}()
+		c.bytesReceived.Add(r.count)
+		c.WriteReplyf(StatusClosingDataConnection, "OK, received %s.", byteCounts(r.count, wire.count))
+	})
 }
 
 type countReader struct {
@@ -879,6 +1003,17 @@ func (r *countReader) Read(b []byte) (int, error) {
 	return n, err
 }
 
+type countWriter struct {
+	io.Writer
+	count int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.count += int64(n)
+	return n, err
+}
+
 // STORE UNIQUE (STOU)
 // This command behaves like STOR except that the resultant
 // file is to be created in the current directory under a name
@@ -890,6 +1025,11 @@ func (commandStou) RequireParam() bool { return false }
 func (commandStou) RequireAuth() bool  { return true }
 
 func (commandStou) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if c.restart > 0 || c.byteRange != nil {
+		c.WriteReply(StatusInvalidRestParameter, "Restarting uploads is not supported.")
+		return
+	}
+
 	// generate unique file name.
 	var name string
 	var buf [16]byte
@@ -899,26 +1039,33 @@ func (commandStou) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	}
 	name = c.buildPath(hex.EncodeToString(buf[:]))
 
-	c.WriteReply(StatusAboutToSend, fmt.Sprintf("Data transfer starting: %s", name))
+	c.WriteReplyf(StatusAboutToSend, "Data transfer starting: %s", name)
 
 	conn, err := c.dt.Conn(ctx)
 	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
 		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
 		return
 	}
 
-	go func() {
+	tctx, cancel := c.newTransferContext()
+	format := c.dataFormat()
+	c.startTransfer(func() {
+		defer cancel()
 		defer c.closeDataTransfer()
-		r := &countReader{Reader: conn}
-		err = c.fileSystem().Create(context.Background(), name, r)
+		wire := &countReader{Reader: conn}
+		r := &countReader{Reader: format.reader(wire)}
+		err = c.fileSystem().Create(tctx, name, r)
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
 		if err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to store file: %v", err)
-			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
+			handleStoreError(c, err)
 			return
 		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("OK, received %d bytes. unique file name: %s", r.count, name))
-	}()
+		c.bytesReceived.Add(r.count)
+		c.WriteReplyf(StatusClosingDataConnection, "OK, received %s. unique file name: %s", byteCounts(r.count, wire.count), name)
+	})
 }
 
 // FILE STRUCTURE (STRU)
@@ -962,10 +1109,10 @@ func (commandSyst) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 //  protocol was more aware of the content of the files it was transferring, and
 //  would sometimes be expected to translate things like EOL markers on the fly.
 //
-//  Valid options were A(SCII), I(mage), E(BCDIC) or LN (for local type). Since
-//  we plan to just accept bytes from the client unchanged, I think Image mode is
-//  adequate. The RFC requires we accept ASCII mode however, so accept it, but
-//  ignore it.
+//  Valid options were A(SCII), I(mage), E(BCDIC) or LN (for local type). We
+//  support Image mode and ASCII mode. In ASCII mode, the line endings are
+//  converted between CRLF on the wire and LF in the file system, unless
+//  Server.ASCIIPassThrough is set.
 type commandType struct{}
 
 func (commandType) IsExtend() bool     { return false }
@@ -974,9 +1121,11 @@ func (commandType) RequireAuth() bool  { return true }
 
 func (commandType) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	switch cmd.Arg {
-	case "A", "a":
+	case "A", "a", "A N", "a n":
+		c.ascii = true
 		c.WriteReply(StatusCommandOK, "Type set to ASCII.")
 	case "I", "i":
+		c.ascii = false
 		c.WriteReply(StatusCommandOK, "Type set to binary.")
 	default:
 		c.WriteReply(StatusBadArguments, "Unknown type.")
@@ -995,6 +1144,117 @@ func (commandUser) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	c.WriteReply(StatusUserOK, "User name ok, password required.")
 }
 
+// HOST (HOST)
+// The HOST command selects the virtual host before USER command.
+// https://tools.ietf.org/html/rfc7151
+type commandHost struct{}
+
+func (commandHost) IsExtend() bool     { return true }
+func (commandHost) RequireParam() bool { return true }
+func (commandHost) RequireAuth() bool  { return false }
+
+func (commandHost) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if c.user != "" || c.auth != nil {
+		c.WriteReply(StatusBadSequence, "HOST must be sent before USER.")
+		return
+	}
+	if len(c.server.VirtualHosts) == 0 {
+		// there is only one host, and it serves any names.
+		c.WriteReply(StatusReady, c.banner()...)
+		return
+	}
+
+	name := hostName(cmd.Arg)
+	if c.server.lookupHost(name) == nil {
+		c.WriteReply(StatusNotImplementedParameter, "Unknown host.")
+		return
+	}
+	if c.sniHost != "" && c.sniHost != name {
+		// RFC 7151 Section 3.3: the name must match the one of TLS.
+		c.WriteReply(StatusNotImplementedParameter, "The host doesn't match the server name of TLS.")
+		return
+	}
+	c.server.logger().Printf(c.getSessionID(), "the virtual host %s is selected", name)
+	c.host = name
+	c.WriteReply(StatusReady, c.banner()...)
+}
+
+// commandSite responds to the SITE command, dispatching it to the subcommand.
+type commandSite struct{}
+
+func (commandSite) IsExtend() bool     { return false }
+func (commandSite) RequireParam() bool { return true }
+func (commandSite) RequireAuth() bool  { return true }
+
+func (commandSite) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(cmd.Arg), " ")
+	name = strings.ToUpper(name)
+	site := c.server.siteCommand(name)
+	if site == nil {
+		c.WriteReplyf(StatusBadCommand, "Unknown SITE command %s.", name)
+		return
+	}
+	if !c.auth.permitsSite(name) {
+		c.WriteReply(StatusFileUnavailable, "Permission is denied.")
+		return
+	}
+	site.Execute(ctx, c, &Command{
+		Name: name,
+		Arg:  strings.TrimSpace(arg),
+	})
+}
+
+// FTP Operation Over Big Address Records (FOOBAR)
+// https://tools.ietf.org/html/rfc1639
+
+// commandLprt is the long address version of PORT command.
+// Some legacy clients use it instead of EPRT.
+type commandLprt struct{}
+
+func (commandLprt) IsExtend() bool     { return false }
+func (commandLprt) RequireParam() bool { return true }
+func (commandLprt) RequireAuth() bool  { return true }
+
+func (commandLprt) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	executeActive(ctx, c, cmd, parseLprtAddress)
+}
+
+// commandLpsv is the long address version of PASV command.
+// Some legacy clients use it instead of EPSV.
+type commandLpsv struct{}
+
+func (commandLpsv) IsExtend() bool     { return false }
+func (commandLpsv) RequireParam() bool { return false }
+func (commandLpsv) RequireAuth() bool  { return true }
+
+func (commandLpsv) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if c.epsvAll {
+		c.WriteReply(StatusBadArguments, "LPSV command is disabled.")
+		return
+	}
+	ip := c.publicIPv4()
+	if ip == nil {
+		addr, ok := c.rwc.LocalAddr().(*net.TCPAddr)
+		if !ok {
+			c.WriteReply(StatusNotImplemented, "LPSV command is disabled.")
+			return
+		}
+		ip = addr.IP
+	}
+	dt, err := c.newPassiveDataTransfer()
+	if err != nil {
+		if err == errPassiveModeIsDisabled {
+			c.WriteReply(StatusNotImplemented, "Passive mode is disabled.")
+			return
+		}
+		c.server.logger().Printf(c.getSessionID(), "fail to enter passive mode: %v", err)
+		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
+		return
+	}
+	addr := dt.l.Addr().(*net.TCPAddr)
+	c.WriteReply(StatusLongPassiveMode, fmt.Sprintf("Entering Long Passive Mode (%s)", formatLongAddress(ip, addr.Port)))
+}
+
 // FTP Security Extensions
 // https://tools.ietf.org/html/rfc2228
 type commandAuth struct{}
@@ -1011,7 +1271,34 @@ func (commandAuth) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	}
 	c.WriteReply(StatusSecurityDataExchangeComplete, "AUTH command OK.")
 	if err := c.upgradeToTLS(); err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to upgrade to tls: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to upgrade to tls: %v", err)
+	}
+}
+
+// commandCcc clears the command channel.
+// It allows NAT firewalls to inspect PORT and PASV commands, while the data connections are still protected.
+// https://tools.ietf.org/html/rfc4217#section-6
+type commandCcc struct{}
+
+func (commandCcc) IsExtend() bool     { return false }
+func (commandCcc) RequireParam() bool { return false }
+func (commandCcc) RequireAuth() bool  { return true }
+
+func (commandCcc) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if !c.server.EnableCCC {
+		c.WriteReply(StatusRequestDenied, "CCC command is disabled.")
+		return
+	}
+	if c.tlsConn == nil {
+		c.WriteReply(StatusCommandProtectionLevelDenied, "The command channel is not protected by AUTH TLS.")
+		return
+	}
+	c.WriteReply(StatusCommandOK, "Clearing the command channel.")
+	if err := c.clearCommandChannel(); err != nil {
+		// the state of the control connection is unknown, so close it.
+		c.server.logger().Printf(c.getSessionID(), "fail to clear the command channel: %v", err)
+		c.shuttingDown.setTrue()
+		c.Close()
 	}
 }
 
@@ -1069,10 +1356,18 @@ func (commandFeat) RequireAuth() bool  { return false }
 func (commandFeat) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	cmds := []string{}
 	for k, v := range commands {
-		if v == nil || !v.IsExtend() {
+		if v == nil {
+			continue
+		}
+		if _, ok := v.(featureParam); !ok && !v.IsExtend() {
+			continue
+		}
+		if (k == "REST" || k == "RANG") && !c.server.EnableRestart {
 			continue
 		}
-		if f, ok := v.(featureParam); ok {
+		if f, ok := v.(connFeatureParam); ok {
+			k += " " + f.ConnFeatureParam(c)
+		} else if f, ok := v.(featureParam); ok {
 			k += " " + f.FeatureParam()
 		}
 		cmds = append(cmds, " "+k)
@@ -1093,20 +1388,63 @@ func (commandOpts) RequireParam() bool { return false }
 func (commandOpts) RequireAuth() bool  { return false }
 
 func (commandOpts) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	parts := strings.Fields(cmd.Arg)
-	if len(parts) != 2 {
+	name, args, _ := strings.Cut(strings.TrimSpace(cmd.Arg), " ")
+	switch strings.ToUpper(name) {
+	case "UTF8":
+		optsUTF8(c, args)
+	case "MODE":
+		optsMode(c, args)
+	case "HASH":
+		optsHash(c, args)
+	case "MLST":
+		optsMlst(c, args)
+	default:
 		c.WriteReply(StatusBadArguments, "Invalid option.")
-		return
 	}
-	if !strings.EqualFold(parts[0], "utf8") {
+}
+
+func optsUTF8(c *ServerConn, args string) {
+	switch strings.ToUpper(strings.TrimSpace(args)) {
+	case "ON":
+		c.utf8 = utf8On
+		c.updateCharset()
+		c.WriteReply(StatusCommandOK, "UTF8 mode enabled.")
+	case "OFF":
+		if c.legacyCharset() == nil {
+			c.WriteReply(StatusBadArguments, "Unsupported non-utf8 mode.")
+			return
+		}
+		c.utf8 = utf8Off
+		c.updateCharset()
+		c.WriteReply(StatusCommandOK, "UTF8 mode disabled.")
+	default:
+		c.WriteReply(StatusBadArguments, "Invalid option.")
+	}
+}
+
+// optsMode sets the options of the transfer mode.
+// https://tools.ietf.org/html/draft-preston-ftpext-deflate-04#section-4
+func optsMode(c *ServerConn, args string) {
+	parts := strings.Fields(args)
+	if len(parts) == 0 || !strings.EqualFold(parts[0], "Z") {
 		c.WriteReply(StatusBadArguments, "Invalid option.")
 		return
 	}
-	if strings.EqualFold(parts[1], "on") {
-		c.WriteReply(StatusCommandOK, "UTF8 mode enabled.")
-	} else {
-		c.WriteReply(StatusBadArguments, "Unsupported non-utf8 mode.")
+	level := c.deflateLevel
+	for i := 1; i < len(parts); i += 2 {
+		if i+1 >= len(parts) || !strings.EqualFold(parts[i], "LEVEL") {
+			c.WriteReply(StatusBadArguments, "Invalid option.")
+			return
+		}
+		l, err := strconv.Atoi(parts[i+1])
+		if err != nil || l < zlib.NoCompression || l > zlib.BestCompression {
+			c.WriteReply(StatusBadArguments, "Invalid compression level.")
+			return
+		}
+		level = l
 	}
+	c.deflateLevel = level
+	c.WriteReplyf(StatusCommandOK, "MODE Z LEVEL set to %d.", level)
 }
 
 // FTP Extensions for IPv6 and NATs
@@ -1120,61 +1458,7 @@ func (commandEprt) RequireParam() bool { return true }
 func (commandEprt) RequireAuth() bool  { return true }
 
 func (commandEprt) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	if c.epsvAll || !c.server.EnableActiveMode {
-		c.WriteReply(StatusBadArguments, "EPRT command is disabled.")
-		return
-	}
-
-	delem := cmd.Arg[:1]
-	params := strings.Split(cmd.Arg, delem)
-	if len(params) < 5 {
-		c.WriteReply(StatusBadArguments, "Syntax error.")
-		return
-	}
-	proto := params[1]
-	addr := params[2]
-	port := params[3]
-
-	var ip net.IP
-	switch proto {
-	case "1": // IP v4
-		ip = net.ParseIP(addr)
-		if ip != nil {
-			ip = ip.To4()
-		}
-	case "2": // IP v6
-		ip = net.ParseIP(addr)
-		if ip != nil {
-			ip = ip.To16()
-		}
-	default:
-		c.WriteReply(StatusNetworkProtoNotSupported, "Network protocol not supported, use (1,2)")
-		return
-	}
-	if ip == nil {
-		c.WriteReply(StatusBadArguments, "Invalid address.")
-		return
-	}
-	portNum, err := strconv.Atoi(port)
-	if err != nil {
-		c.WriteReply(StatusBadArguments, "Invalid port number.")
-		return
-	}
-
-	// https://tools.ietf.org/html/rfc2577
-	// Protecting Against the Bounce Attack
-	if portNum < 1024 || portNum > 65535 {
-		c.WriteReply(StatusNotImplemented, "Command not implemented for that parameter.")
-		return
-	}
-
-	_, err = c.newActiveDataTransfer(ctx, fmt.Sprintf("%s:%d", ip.String(), portNum))
-	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to enter active mode: %v", err)
-		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
-		return
-	}
-	c.WriteReply(StatusCommandOK, "Okay.")
+	executeActive(ctx, c, cmd, parseEprtAddress)
 }
 
 // commandEpsv requests that a server listen on a data port and wait for a connection
@@ -1204,7 +1488,7 @@ func (commandEpsv) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 			c.WriteReply(StatusNotImplemented, "Passive mode is disabled.")
 			return
 		}
-		c.server.logger().Printf(c.sessionID, "fail to enter passive mode: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to enter passive mode: %v", err)
 		c.WriteReply(StatusCanNotOpenDataConnection, "Data connection failed.")
 		return
 	}
@@ -1216,22 +1500,41 @@ func (commandEpsv) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 // https://tools.ietf.org/html/rfc2640
 type commandLang struct{}
 
-func (commandLang) IsExtend() bool       { return true }
-func (commandLang) RequireParam() bool   { return true }
-func (commandLang) RequireAuth() bool    { return false }
-func (commandLang) FeatureParam() string { return "EN*" }
+func (commandLang) IsExtend() bool     { return true }
+func (commandLang) RequireParam() bool { return false }
+func (commandLang) RequireAuth() bool  { return false }
+
+// ConnFeatureParam returns the supported languages.
+// The language of the connection is marked with an asterisk, e.g. "EN*;JA".
+func (commandLang) ConnFeatureParam(c *ServerConn) string {
+	langs := c.server.languages()
+	for i, lang := range langs {
+		langs[i] = strings.ToUpper(lang)
+		if lang == c.lang {
+			langs[i] += "*"
+		}
+	}
+	return strings.Join(langs, ";")
+}
 
 func (commandLang) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
-	args := strings.Split(cmd.Arg, "-")
-	if len(args) < 1 {
+	if cmd.Arg == "" {
+		// return to the default language.
+		c.setLanguage(c.server.language())
+		c.WriteReplyf(StatusCommandOK, "Language change to %s", c.lang)
+		return
+	}
+	if strings.ContainsAny(cmd.Arg, " \t") {
 		c.WriteReply(StatusBadArguments, "Syntax error in parameter.")
 		return
 	}
-	if !strings.EqualFold(args[0], "en") {
-		c.WriteReply(StatusNotImplemented, fmt.Sprintf("Language %s is not supported.", cmd.Arg))
+	lang := c.server.matchLanguage(cmd.Arg)
+	if lang == "" {
+		c.WriteReplyf(StatusNotImplementedParameter, "Language %s is not supported.", cmd.Arg)
 		return
 	}
-	c.WriteReply(StatusCommandOK, fmt.Sprintf("Language change to %s", cmd.Arg))
+	c.setLanguage(lang)
+	c.WriteReplyf(StatusCommandOK, "Language change to %s", lang)
 }
 
 // Extensions to FTP
@@ -1247,7 +1550,8 @@ func (commandMdtm) RequireAuth() bool  { return true }
 func (commandMdtm) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	fs := c.fileSystem()
 	path := c.buildPath(cmd.Arg)
-	stat, err := fs.Stat(ctx, path)
+	// the modification time may be set by MFMT.
+	stat, err := fs.Stat(vfs.WithMetadata(ctx), path)
 	if err != nil {
 		handleFileError(c, err)
 		return
@@ -1258,25 +1562,41 @@ func (commandMdtm) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 // Listings for Machine Processing (MLST and MLSD)
 type commandMlst struct{}
 
-func (commandMlst) IsExtend() bool       { return true }
-func (commandMlst) RequireParam() bool   { return true }
-func (commandMlst) RequireAuth() bool    { return true }
-func (commandMlst) FeatureParam() string { return "Type*,Modify*,Size*,Perm*" }
+func (commandMlst) IsExtend() bool     { return true }
+func (commandMlst) RequireParam() bool { return false }
+func (commandMlst) RequireAuth() bool  { return true }
+
+// ConnFeatureParam returns the supported facts, and marks the selected ones with "*".
+func (commandMlst) ConnFeatureParam(c *ServerConn) string {
+	var builder strings.Builder
+	selected := c.selectedFacts()
+	for _, fact := range mlstFacts {
+		builder.WriteString(fact)
+		if slices.Contains(selected, fact) {
+			builder.WriteByte('*')
+		}
+		builder.WriteByte(';')
+	}
+	return builder.String()
+}
 
 func (commandMlst) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	path := c.pwd
 	if cmd.Arg != "" {
 		path = c.buildPath(cmd.Arg)
 	}
-	stat, err := c.fileSystem().Stat(ctx, path)
+	// the facts include the attributes set by MFF, MFMT and SITE CHMOD.
+	stat, err := c.fileSystem().Stat(vfs.WithMetadata(ctx), path)
 	if err != nil {
 		handleFileError(c, err)
 		return
 	}
+
+	// the pathname in the response is the full path, even if the object is a directory.
 	c.WriteReply(
 		StatusFile,
-		"Listing "+path,
-		formatMachineListings(stat),
+		fmt.Sprintf(c.message("Listing %s"), path),
+		" "+c.formatFacts(stat, path, "")+" "+path,
 		"End.",
 	)
 }
@@ -1288,56 +1608,185 @@ func (commandMlsd) RequireParam() bool { return false }
 func (commandMlsd) RequireAuth() bool  { return true }
 
 func (commandMlsd) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	// the facts must be same as MLST, e.g. the modification time set by MFMT.
+	ctx = vfs.WithMetadata(ctx)
+	fs := c.fileSystem()
 	path := c.pwd
 	if cmd.Arg != "" {
 		path = c.buildPath(cmd.Arg)
 	}
-	info, err := c.fileSystem().ReadDir(ctx, path)
+	cdir, err := fs.Stat(ctx, path)
 	if err != nil {
 		handleFileError(c, err)
 		return
 	}
+	if !cdir.IsDir() {
+		c.WriteReply(StatusBadArguments, "Not a directory.")
+		return
+	}
+	info, err := fs.ReadDir(ctx, path)
+	if err != nil && !os.IsNotExist(err) {
+		// some file systems return ErrNotExist for empty directories.
+		handleFileError(c, err)
+		return
+	}
+
+	// the facts of the directory itself and its parent come first.
+	lines := []string{c.formatFacts(cdir, path, "cdir") + " " + path}
+	if path != "/" {
+		parent := pkgpath.Dir(path)
+		if pdir, err := fs.Stat(ctx, parent); err == nil {
+			lines = append(lines, c.formatFacts(pdir, parent, "pdir")+" "+parent)
+		}
+	}
+	for _, fi := range info {
+		lines = append(lines, c.formatFacts(fi, pkgpath.Join(path, fi.Name()), "")+" "+fi.Name())
+	}
 	c.WriteReply(StatusAboutToSend, "File status okay; about to open data connection.")
 
 	conn, err := c.dt.Conn(ctx)
 	if err != nil {
-		c.server.logger().Printf(c.sessionID, "fail to start data connection: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
 		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
 		return
 	}
 
-	go func() {
+	tctx, cancel := c.newTransferContext()
+	charset := c.charset
+	format := c.dataFormat()
+	c.startTransfer(func() {
 		defer c.closeDataTransfer()
-		w := bufio.NewWriter(conn)
+		defer cancel()
+		wire := &countWriter{Writer: conn}
+		dw := format.writer(wire, 0)
+		w := bufio.NewWriter(dw)
 		bytes := int64(0)
-		for _, fi := range info {
-			n, _ := fmt.Print(w, formatMachineListings(fi), "\r\n")
+		for _, line := range lines {
+			n, _ := fmt.Fprint(w, encodeString(charset, line), "\r\n")
 			bytes += int64(n)
 		}
-		if err := w.Flush(); err != nil {
-			c.server.logger().Printf(c.sessionID, "fail to list directory: %v", err)
+		err := w.Flush()
+		if err == nil {
+			err = dw.Close()
+		}
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
+		if err != nil {
+			c.server.logger().Printf(c.getSessionID(), "fail to list directory: %v", err)
 			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
 			return
 		}
-		c.WriteReply(StatusClosingDataConnection, fmt.Sprintf("Data transfer starting %d bytes", bytes))
-	}()
+		c.bytesSent.Add(bytes)
+		c.WriteReplyf(StatusClosingDataConnection, "Data transfer starting %s", byteCounts(bytes, wire.count))
+	})
 }
 
-func formatMachineListings(stat os.FileInfo) string {
-	var builder strings.Builder
-	if stat.IsDir() {
-		builder.WriteString("Type=dir;")
-	} else {
-		builder.WriteString("Type=file;")
+// the facts that MLST and MLSD commands support, in the order of the output.
+// https://tools.ietf.org/html/rfc3659#section-7.5
+// https://www.iana.org/assignments/os-specific-parameters
+var mlstFacts = []string{
+	"Type",
+	"Size",
+	"Modify",
+	"Create",
+	"Perm",
+	"Unique",
+	"Media-Type",
+	"UNIX.mode",
+	"UNIX.owner",
+	"UNIX.group",
+}
+
+// the facts selected if the client doesn't select them by OPTS MLST.
+var defaultMlstFacts = []string{"Type", "Size", "Modify", "Perm"}
+
+// selectedFacts returns the facts selected by OPTS MLST.
+func (c *ServerConn) selectedFacts() []string {
+	if c.mlstFacts == nil {
+		return defaultMlstFacts
 	}
+	return c.mlstFacts
+}
 
-	builder.WriteString("Modify=")
-	builder.WriteString(stat.ModTime().UTC().Format("20060102150405.999"))
-	builder.WriteString(";")
+// optsMlst selects the facts of MLST and MLSD commands.
+// https://tools.ietf.org/html/rfc3659#section-7.9
+func optsMlst(c *ServerConn, args string) {
+	// the unsupported facts are ignored.
+	selected := []string{}
+	for _, name := range strings.Split(strings.TrimSpace(args), ";") {
+		for _, fact := range mlstFacts {
+			if strings.EqualFold(name, fact) && !slices.Contains(selected, fact) {
+				selected = append(selected, fact)
+			}
+		}
+	}
+	// keep the order of the output.
+	sort.Slice(selected, func(i, j int) bool {
+		return slices.Index(mlstFacts, selected[i]) < slices.Index(mlstFacts, selected[j])
+	})
+	c.mlstFacts = selected
+
+	var builder strings.Builder
+	builder.WriteString("MLST OPTS ")
+	for _, fact := range selected {
+		builder.WriteString(fact)
+		builder.WriteByte(';')
+	}
+	c.WriteReply(StatusCommandOK, builder.String())
+}
 
-	fmt.Fprintf(&builder, "Size=%d;", stat.Size())
+// formatFacts formats the selected facts of the file.
+// typ overrides the Type fact for the entries of the directory itself ("cdir") and its parent ("pdir").
+func (c *ServerConn) formatFacts(stat os.FileInfo, path, typ string) string {
+	var builder strings.Builder
+	for _, fact := range c.selectedFacts() {
+		switch fact {
+		case "Type":
+			if typ == "" {
+				typ = "file"
+				if stat.IsDir() {
+					typ = "dir"
+				}
+			}
+			fmt.Fprintf(&builder, "Type=%s;", typ)
+		case "Size":
+			fmt.Fprintf(&builder, "Size=%d;", stat.Size())
+		case "Modify":
+			fmt.Fprintf(&builder, "Modify=%s;", stat.ModTime().UTC().Format("20060102150405.999"))
+		case "Create":
+			if ctime := vfs.CreateTime(stat); !ctime.IsZero() {
+				fmt.Fprintf(&builder, "Create=%s;", ctime.UTC().Format("20060102150405.999"))
+			}
+		case "Perm":
+			fmt.Fprintf(&builder, "Perm=%s;", formatPerm(stat))
+		case "Unique":
+			// the file system has no inode, so the path identifies the object.
+			h := fnv.New64a()
+			io.WriteString(h, path)
+			fmt.Fprintf(&builder, "Unique=%016x;", h.Sum64())
+		case "Media-Type":
+			if mediaType := vfs.ContentType(stat); mediaType != "" && !stat.IsDir() {
+				fmt.Fprintf(&builder, "Media-Type=%s;", mediaType)
+			}
+		case "UNIX.mode":
+			fmt.Fprintf(&builder, "UNIX.mode=%04o;", stat.Mode().Perm())
+		case "UNIX.owner":
+			if owner, _ := vfs.Owner(stat); owner != "" {
+				fmt.Fprintf(&builder, "UNIX.owner=%s;", owner)
+			}
+		case "UNIX.group":
+			if _, group := vfs.Owner(stat); group != "" {
+				fmt.Fprintf(&builder, "UNIX.group=%s;", group)
+			}
+		}
+	}
+	return builder.String()
+}
 
-	builder.WriteString("Perm=")
+// formatPerm formats the value of the Perm fact.
+func formatPerm(stat os.FileInfo) string {
+	var builder strings.Builder
 	isDir := stat.IsDir()
 	mode := stat.Mode()
 	if !isDir && (mode&0600) == 0600 {
@@ -1369,12 +1818,50 @@ func formatMachineListings(stat os.FileInfo) string {
 		// the STOR command may be applied to that object
 		builder.WriteString("w")
 	}
-	builder.WriteString(";")
+	return builder.String()
+}
+
+// commandRest sets the offset for restarting the next transfer.
+// The offset is a byte count in stream mode,
+// and a restart marker sent by RETR in block mode.
+// The markers are also byte counts, so both are treated in the same way.
+// Only RETR supports restarting, because uploads are committed at once.
+// It is disabled by default, see Server.EnableRestart.
+type commandRest struct{}
+
+func (commandRest) IsExtend() bool       { return true }
+func (commandRest) RequireParam() bool   { return true }
+func (commandRest) RequireAuth() bool    { return true }
+func (commandRest) FeatureParam() string { return "STREAM" }
+
+func (commandRest) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if !checkRestart(c, cmd) {
+		return
+	}
 
-	builder.WriteString(" ")
-	builder.WriteString(stat.Name())
+	offset, err := strconv.ParseInt(cmd.Arg, 10, 64)
+	if err != nil || offset < 0 {
+		c.WriteReply(StatusBadArguments, "Invalid restart marker.")
+		return
+	}
+	c.restart = offset
+	c.WriteReplyf(StatusRequestFilePending, "Restarting at %d. Send RETR to initiate transfer.", offset)
+}
 
-	return builder.String()
+// checkRestart reports whether REST and RANG commands are available.
+// If they are not, it writes the reply.
+func checkRestart(c *ServerConn, cmd *Command) bool {
+	if !c.server.EnableRestart {
+		c.WriteReplyf(StatusNotImplemented, "%s command is disabled.", cmd.Name)
+		return false
+	}
+	// the offsets of the converted line endings and the compressed data
+	// don't match the offsets in the file.
+	if c.convertsASCII() || c.mode == transferModeDeflate {
+		c.WriteReplyf(StatusNotImplementedParameter, "%s is only supported in binary type with stream or block mode.", cmd.Name)
+		return false
+	}
+	return true
 }
 
 // commandSize return the file size.
@@ -1385,6 +1872,12 @@ func (commandSize) RequireParam() bool { return true }
 func (commandSize) RequireAuth() bool  { return true }
 
 func (commandSize) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	if c.convertsASCII() {
+		// the size depends on the line ending conversion, and it needs to read the whole file.
+		// https://tools.ietf.org/html/rfc3659#section-4
+		c.WriteReply(StatusFileUnavailable, "SIZE not allowed in ASCII mode.")
+		return
+	}
 	fs := c.fileSystem()
 	path := c.buildPath(cmd.Arg)
 	stat, err := fs.Stat(ctx, path)
@@ -1395,6 +1888,376 @@ func (commandSize) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
 	c.WriteReply(StatusFile, strconv.FormatInt(stat.Size(), 10))
 }
 
+// parseTimeVal parses the time-val in RFC 3659, e.g. "20060102150405.999".
+// The time is in UTC.
+func parseTimeVal(value string) (time.Time, error) {
+	if len(value) < len("20060102150405") {
+		return time.Time{}, errors.New("ftp: invalid time-val")
+	}
+	// the fraction part is accepted even if the layout doesn't have it.
+	return time.Parse("20060102150405", value)
+}
+
+// commandMfmt sets the modification time of the file.
+type commandMfmt struct{}
+
+func (commandMfmt) IsExtend() bool     { return true }
+func (commandMfmt) RequireParam() bool { return true }
+func (commandMfmt) RequireAuth() bool  { return true }
+
+func (commandMfmt) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	value, path, ok := strings.Cut(cmd.Arg, " ")
+	if !ok || path == "" {
+		c.WriteReply(StatusBadArguments, "Syntax: MFMT <time-val> <path>")
+		return
+	}
+	mtime, err := parseTimeVal(value)
+	if err != nil {
+		c.WriteReply(StatusBadArguments, "Invalid time.")
+		return
+	}
+	if err := vfs.Chtimes(ctx, c.fileSystem(), c.buildPath(path), mtime, time.Time{}); err != nil {
+		handleAttrError(c, err)
+		return
+	}
+	c.WriteReply(StatusFile, fmt.Sprintf("Modify=%s; %s", value, path))
+}
+
+// commandMfct sets the creation time of the file.
+type commandMfct struct{}
+
+func (commandMfct) IsExtend() bool     { return true }
+func (commandMfct) RequireParam() bool { return true }
+func (commandMfct) RequireAuth() bool  { return true }
+
+func (commandMfct) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	value, path, ok := strings.Cut(cmd.Arg, " ")
+	if !ok || path == "" {
+		c.WriteReply(StatusBadArguments, "Syntax: MFCT <time-val> <path>")
+		return
+	}
+	ctime, err := parseTimeVal(value)
+	if err != nil {
+		c.WriteReply(StatusBadArguments, "Invalid time.")
+		return
+	}
+	if err := vfs.Chtimes(ctx, c.fileSystem(), c.buildPath(path), time.Time{}, ctime); err != nil {
+		handleAttrError(c, err)
+		return
+	}
+	c.WriteReply(StatusFile, fmt.Sprintf("Create=%s; %s", value, path))
+}
+
+// commandMff sets the facts of the file.
+// The supported facts are Modify, Create and UNIX.mode.
+type commandMff struct{}
+
+func (commandMff) IsExtend() bool       { return true }
+func (commandMff) RequireParam() bool   { return true }
+func (commandMff) RequireAuth() bool    { return true }
+func (commandMff) FeatureParam() string { return "Modify;Create;UNIX.mode;" }
+
+func (commandMff) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	facts, path, ok := strings.Cut(cmd.Arg, " ")
+	if !ok || path == "" {
+		c.WriteReply(StatusBadArguments, "Syntax: MFF <facts> <path>")
+		return
+	}
+
+	// check all facts before changing the file.
+	var mtime, ctime time.Time
+	var mode *os.FileMode
+	var set strings.Builder
+	for _, fact := range strings.Split(facts, ";") {
+		if fact == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(fact, "=")
+		if !ok {
+			c.WriteReply(StatusBadArguments, "Invalid fact.")
+			return
+		}
+		var err error
+		switch strings.ToLower(name) {
+		case "modify":
+			mtime, err = parseTimeVal(value)
+		case "create":
+			ctime, err = parseTimeVal(value)
+		case "unix.mode":
+			var m uint64
+			m, err = strconv.ParseUint(value, 8, 32)
+			if err == nil && m > 0777 {
+				err = errors.New("ftp: invalid mode")
+			}
+			perm := os.FileMode(m)
+			mode = &perm
+		default:
+			c.WriteReplyf(StatusNotImplementedParameter, "Unsupported fact %s.", name)
+			return
+		}
+		if err != nil {
+			c.WriteReplyf(StatusBadArguments, "Invalid value of %s.", name)
+			return
+		}
+		set.WriteString(fact)
+		set.WriteString(";")
+	}
+	if set.Len() == 0 {
+		c.WriteReply(StatusBadArguments, "No facts.")
+		return
+	}
+
+	fs := c.fileSystem()
+	name := c.buildPath(path)
+	if mode != nil {
+		if err := vfs.Chmod(ctx, fs, name, *mode); err != nil {
+			handleAttrError(c, err)
+			return
+		}
+	}
+	if !mtime.IsZero() || !ctime.IsZero() {
+		if err := vfs.Chtimes(ctx, fs, name, mtime, ctime); err != nil {
+			handleAttrError(c, err)
+			return
+		}
+	}
+	c.WriteReply(StatusFile, fmt.Sprintf("%s %s", set.String(), path))
+}
+
+// hashAlgorithms are the algorithms supported by HASH command.
+var hashAlgorithms = []string{
+	vfs.HashCRC32,
+	vfs.HashCRC32C,
+	vfs.HashMD5,
+	vfs.HashSHA1,
+	vfs.HashSHA256,
+	vfs.HashSHA512,
+}
+
+// byteRange is a range of bytes in a file. Both start and end are inclusive.
+type byteRange struct {
+	start, end int64
+}
+
+var (
+	errInvalidRange = errors.New("ftp: invalid byte range")
+	errIsDirectory  = errors.New("ftp: is a directory")
+)
+
+// hashFile returns the checksum of the byte range r in the file, and the actual range.
+// If r is nil, hashFile returns the checksum of the whole file.
+func hashFile(ctx context.Context, c *ServerConn, path, algorithm string, r *byteRange) (string, *byteRange, error) {
+	fs := c.fileSystem()
+	stat, err := fs.Stat(ctx, path)
+	if err != nil {
+		return "", nil, err
+	}
+	if stat.IsDir() {
+		return "", nil, &os.PathError{
+			Op:   "hash",
+			Path: path,
+			Err:  errIsDirectory,
+		}
+	}
+
+	size := stat.Size()
+	last := size - 1
+	if last < 0 {
+		last = 0
+	}
+	if r == nil || (r.start == 0 && r.end >= last) {
+		// the file system may have the checksum of the whole file.
+		sum, err := vfs.Hash(ctx, fs, path, algorithm)
+		return sum, &byteRange{0, last}, err
+	}
+	if r.start >= size {
+		return "", nil, errInvalidRange
+	}
+	actual := &byteRange{r.start, r.end}
+	if actual.end > last {
+		actual.end = last
+	}
+	sum, err := vfs.HashRange(ctx, fs, path, algorithm, actual.start, actual.end-actual.start+1)
+	return sum, actual, err
+}
+
+// startHash computes the checksum of the file in the background, same as the data transfers.
+// If the file system doesn't have the checksum, it reads the whole file and takes long time,
+// so it has no deadline and ABOR can cancel it.
+// reply is called with the checksum and the actual range.
+func startHash(c *ServerConn, path, algorithm string, r *byteRange, reply func(sum string, r *byteRange)) {
+	tctx, cancel := c.newTransferContext()
+	c.startTransfer(func() {
+		defer cancel()
+		sum, actual, err := hashFile(tctx, c, path, algorithm, r)
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
+		if err != nil {
+			handleHashError(c, err)
+			return
+		}
+		reply(sum, actual)
+	})
+}
+
+// helper function for handling errors of hashFile.
+func handleHashError(c *ServerConn, err error) {
+	if errors.Is(err, errInvalidRange) {
+		c.WriteReply(StatusBadArguments, "Invalid byte range.")
+		return
+	}
+	if errors.Is(err, errIsDirectory) {
+		c.WriteReply(StatusFileUnavailable, "Not a plain file.")
+		return
+	}
+	handleFileError(c, err)
+}
+
+// commandHash returns the checksum of the file in the algorithm selected by OPTS HASH.
+type commandHash struct{}
+
+func (commandHash) IsExtend() bool     { return true }
+func (commandHash) RequireParam() bool { return true }
+func (commandHash) RequireAuth() bool  { return true }
+
+// ConnFeatureParam returns the supported algorithms, and marks the selected one with "*".
+func (commandHash) ConnFeatureParam(c *ServerConn) string {
+	algorithms := make([]string, 0, len(hashAlgorithms))
+	for _, algorithm := range hashAlgorithms {
+		if algorithm == c.hashAlgorithm {
+			algorithm += "*"
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	return strings.Join(algorithms, ";")
+}
+
+func (commandHash) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	path := c.buildPath(cmd.Arg)
+	algorithm := c.hashAlgorithm
+	startHash(c, path, algorithm, c.byteRange, func(sum string, r *byteRange) {
+		c.WriteReply(StatusFile, fmt.Sprintf("%s %d-%d %s %s", algorithm, r.start, r.end, sum, cmd.Arg))
+	})
+}
+
+// optsHash selects the algorithm of HASH command.
+// https://tools.ietf.org/html/draft-bryan-ftpext-hash-02#section-4
+func optsHash(c *ServerConn, args string) {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		c.WriteReply(StatusCommandOK, c.hashAlgorithm)
+		return
+	}
+	for _, algorithm := range hashAlgorithms {
+		if strings.EqualFold(algorithm, args) {
+			c.hashAlgorithm = algorithm
+			c.WriteReply(StatusCommandOK, algorithm)
+			return
+		}
+	}
+	c.WriteReply(StatusNotImplementedParameter, "Unknown algorithm.")
+}
+
+// commandRang sets the byte range for the next RETR or HASH command.
+type commandRang struct{}
+
+func (commandRang) IsExtend() bool       { return true }
+func (commandRang) RequireParam() bool   { return true }
+func (commandRang) RequireAuth() bool    { return true }
+func (commandRang) FeatureParam() string { return "STREAM" }
+
+func (commandRang) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	// RANG is another way to restart RETR, so it is disabled with REST.
+	if !checkRestart(c, cmd) {
+		return
+	}
+
+	fields := strings.Fields(cmd.Arg)
+	if len(fields) != 2 {
+		c.WriteReply(StatusBadArguments, "Syntax: RANG <start> <end>")
+		return
+	}
+	start, err1 := strconv.ParseInt(fields[0], 10, 64)
+	end, err2 := strconv.ParseInt(fields[1], 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < 0 {
+		c.WriteReply(StatusBadArguments, "Invalid byte range.")
+		return
+	}
+	if start == 1 && end == 0 {
+		c.byteRange = nil
+		c.WriteReply(StatusRequestFilePending, "Resetting byte range.")
+		return
+	}
+	if start > end {
+		c.WriteReply(StatusBadArguments, "Invalid byte range.")
+		return
+	}
+	if c.restart > 0 {
+		c.WriteReply(StatusBadSequence, "RANG can't be used with REST.")
+		return
+	}
+	c.byteRange = &byteRange{start, end}
+	c.WriteReplyf(StatusRequestFilePending, "Restarting at %d. Ending at %d.", start, end)
+}
+
+// commandXhash returns the checksum of the file.
+// It is for legacy clients, use HASH command instead.
+//
+//	XMD5 <path>
+//	XMD5 "<path>" [<start> [<end>]]
+//
+// The range is from start to end exclusive.
+type commandXhash struct {
+	algorithm string
+}
+
+func (commandXhash) IsExtend() bool     { return true }
+func (commandXhash) RequireParam() bool { return true }
+func (commandXhash) RequireAuth() bool  { return true }
+
+func (cmd commandXhash) Execute(ctx context.Context, c *ServerConn, command *Command) {
+	name, r, err := parseXhashArg(command.Arg)
+	if err != nil {
+		c.WriteReply(StatusBadArguments, "Syntax error in parameters or arguments.")
+		return
+	}
+	startHash(c, c.buildPath(name), cmd.algorithm, r, func(sum string, _ *byteRange) {
+		c.WriteReply(StatusRequestedFileActionOK, sum)
+	})
+}
+
+func parseXhashArg(arg string) (string, *byteRange, error) {
+	if !strings.HasPrefix(arg, `"`) {
+		return arg, nil, nil
+	}
+	name, rest, ok := strings.Cut(arg[1:], `"`)
+	if !ok || name == "" {
+		return "", nil, errors.New("ftp: unterminated quoted path")
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return name, nil, nil
+	}
+	if len(fields) > 2 {
+		return "", nil, errors.New("ftp: too many arguments")
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || start < 0 {
+		return "", nil, errors.New("ftp: invalid start position")
+	}
+	r := &byteRange{start: start, end: math.MaxInt64}
+	if len(fields) == 2 {
+		end, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || end <= start {
+			return "", nil, errors.New("ftp: invalid end position")
+		}
+		r.end = end - 1
+	}
+	return name, r, nil
+}
+
 // commandReject is used for rejecting unsupported protocols, such as http.
 // protects from web browsers which are attacked.
 type commandReject struct{}
@@ -3,13 +3,20 @@ package ftp_test
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
+	pathpkg "path"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/shogo82148/s3ftpgateway/vfs"
@@ -18,6 +25,7 @@ import (
 	"github.com/shogo82148/s3ftpgateway/ftp"
 	"github.com/shogo82148/s3ftpgateway/ftp/ftptest"
 	"github.com/shogo82148/s3ftpgateway/vfs/mapfs"
+	"golang.org/x/text/encoding/japanese"
 )
 
 type perlExecutor struct {
@@ -390,8 +398,78 @@ my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
 my @files = $ftp->dir();
-is $files[0], 'drwxr-xr-x 1 anonymous anonymous             0  Jan  1 00:00 foo';
-is $files[1], '-rw-r--r-- 1 anonymous anonymous             6  Jan  1 00:00 hogehoge.txt';
+is $files[0], 'drwxr-xr-x 2 anonymous anonymous             0  Jan  1  0001 foo';
+is $files[1], '-rw-r--r-- 1 anonymous anonymous             6  Jan  1  0001 hogehoge.txt';
+ok $ftp->quit();
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestList_Options(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		".hidden":          "secret",
+		"a.csv":            "a",
+		"b.csv":            "bbb",
+		"c.txt":            "cc",
+		"foo/bar/hoge.txt": "abc123",
+		"foo/d.csv":        "dddd",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.MaxListDepth = 1
+	ts.Config.MaxListEntries = 6
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+is_deeply [$ftp->ls()], ['a.csv', 'b.csv', 'c.txt', 'foo'], 'hide dot files';
+is_deeply [$ftp->ls('-a')], ['.hidden', 'a.csv', 'b.csv', 'c.txt', 'foo'], '-a';
+is_deeply [$ftp->ls('-S')], ['b.csv', 'c.txt', 'a.csv', 'foo'], '-S';
+is_deeply [$ftp->ls('*.csv')], ['a.csv', 'b.csv'], 'glob';
+is_deeply [$ftp->ls('foo/*.csv')], ['foo/d.csv'], 'glob in a directory';
+is_deeply [$ftp->ls('c.txt')], ['c.txt'], 'file';
+is_deeply [$ftp->ls('-R foo')], ['bar', 'd.csv', 'bar/hoge.txt'], '-R';
+ok !$ftp->ls('*.xml'), 'no match';
+is $ftp->code, 431, 'no match';
+ok !$ftp->ls('[a'), 'bad pattern';
+is $ftp->code, 501, 'bad pattern';
+
+my @files = $ftp->dir('-la foo');
+is $files[0], 'drwxr-xr-x 2 anonymous anonymous             0  Jan  1  0001 bar', '-la';
+is $files[1], '-rw-r--r-- 1 anonymous anonymous             4  Jan  1  0001 d.csv', '-la';
+
+@files = $ftp->dir('-R');
+is_deeply [map { s/^.* //r } @files], ['.:', 'a.csv', 'b.csv', 'c.txt', 'foo', '', 'foo:', 'bar', 'd.csv'], 'depth limit';
+
+# the listing is truncated.
+@files = $ftp->ls('-aR');
+is scalar @files, 6, 'entry limit';
+like $ftp->message, qr/truncated/, 'entry limit';
+
 ok $ftp->quit();
 done_testing;
 `
@@ -700,7 +778,8 @@ func TestStat(t *testing.T) {
 	defer cancel()
 
 	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
-		"foo.txt": "hello",
+		"foo.txt":     "hello",
+		"bar/baz.txt": "hello world",
 	}))
 	ts.Config.Logger = testLogger{t}
 	ts.Start()
@@ -729,6 +808,26 @@ ${*$ftp}{net_ftp_supported} = {
 
 is $ftp->size('foo.txt'), 5, "stat";
 
+is $ftp->quot('STAT', 'bar'), 2, 'directory';
+is $ftp->code, 212, 'directory';
+like $ftp->message, qr/^-rw-r--r-- 1 anonymous anonymous +11 .* baz.txt$/m, 'directory';
+
+is $ftp->quot('STAT', '-R /'), 2, 'recursive';
+like $ftp->message, qr/^bar:$/m, 'recursive';
+like $ftp->message, qr/ baz.txt$/m, 'recursive';
+
+is $ftp->quot('STAT', 'qux'), 5, 'not found';
+
+ok $ftp->binary, 'TYPE I';
+my $conn = $ftp->retr('foo.txt');
+$conn->read(my $data, 1024);
+ok $conn->close, 'RETR';
+is $ftp->quot('STAT'), 2, 'session status';
+is $ftp->code, 211, 'session status';
+like $ftp->message, qr/Logged in as anonymous/, 'session status';
+like $ftp->message, qr/TYPE: Image, MODE: Stream/, 'session status';
+like $ftp->message, qr/5 bytes sent, 0 bytes received/, 'session status';
+
 ok $ftp->quit;
 
 done_testing;
@@ -787,7 +886,23 @@ done_testing;
 	}
 }
 
-func TestStou(t *testing.T) {
+// noOverwriteFS rejects overwriting existing files.
+type noOverwriteFS struct {
+	vfs.FileSystem
+}
+
+func (fs noOverwriteFS) Create(ctx context.Context, name string, body io.Reader) error {
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return &os.PathError{
+			Op:   "create",
+			Path: name,
+			Err:  vfs.ErrConflict,
+		}
+	}
+	return fs.FileSystem.Create(ctx, name, body)
+}
+
+func TestStor_Conflict(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -797,8 +912,11 @@ func TestStou(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	fs := mapfs.New(map[string]string{})
-	ts := ftptest.NewUnstartedServer(fs)
+	fs := mapfs.New(map[string]string{
+		"foo.txt": "foo",
+		"bar.txt": "bar",
+	})
+	ts := ftptest.NewUnstartedServer(noOverwriteFS{fs})
 	ts.Config.Logger = testLogger{t}
 	ts.Start()
 	defer ts.Close()
@@ -818,38 +936,29 @@ my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
 
-eval {
-	my $name1 = do {
-		my $content = "Hello ftp!";
-		open my $fh, "<", \$content;
-		ok my $name = $ftp->put_unique($fh), 'put_unique';
-		close $fh;
-		$name;
-	};
-	
-	my $name2 = do {
-		my $content = "Hello ftp!";
-		open my $fh, "<", \$content;
-		ok my $name = $ftp->put_unique($fh), 'put_unique';
-		close $fh;
-		$name;
-	};
-	
-	isnt $name1, $name2;
-};
-if ($@ =~ /Must specify remote filename with stream input/i) {
-	# put_unique doesn't work correctly on old version of Net::FTP.
-	plan skip_all => 'put_unique is not support';
-}
-
+my $content = "Hello ftp!";
+open my $fh, "<", \$content;
+ok !$ftp->put($fh, 'foo.txt'), 'put';
+is $ftp->code, 550, 'conflict';
+ok !$ftp->rename('foo.txt', 'bar.txt'), 'rename';
+is $ftp->code, 550, 'conflict';
 ok $ftp->quit(), 'quit';
 done_testing;
 `
 
 	perl.Prove(ctx, t, script, u.Host)
+
+	r, err := fs.Open(ctx, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if string(b) != "foo" {
+		t.Errorf("want foo, got %s", b)
+	}
 }
 
-func TestFeat(t *testing.T) {
+func TestModeBlock(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -862,6 +971,7 @@ func TestFeat(t *testing.T) {
 	fs := mapfs.New(map[string]string{})
 	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableRestart = true
 	ts.Start()
 	defer ts.Close()
 
@@ -879,18 +989,96 @@ use Net::FTP;
 my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
-
-ok $ftp->feature('EPRT'), 'EPRT is supported';
-ok !$ftp->feature('HOGEFUGA'), 'HOGEFUGA is not supported';
+ok $ftp->binary, 'binary';
+is $ftp->quot('MODE', 'B'), 2, 'MODE B';
+
+# upload with an EOF block.
+my $conn = $ftp->stor('foo.txt') or die "fail to stor";
+my $block = pack('Cn', 0, 5) . 'Hello' . pack('Cn', 64, 5) . ' ftp!';
+$conn->write($block, length $block);
+ok $conn->close, 'stor';
+
+# the data connection is closed before the EOF block.
+$conn = $ftp->stor('bar.txt') or die "fail to stor";
+$block = pack('Cn', 0, 5) . 'Hello';
+$conn->write($block, length $block);
+ok !$conn->close, 'stor without EOF block';
+is $ftp->code, 426, 'transfer aborted';
+
+# download from the middle.
+$ftp->restart(6);
+$conn = $ftp->retr('foo.txt') or die "fail to retr";
+my $data = '';
+my $buf;
+while ($conn->read($buf, 1024)) {
+	$data .= $buf;
+}
+ok $conn->close, 'retr';
+is $data, pack('Cn', 0, 4) . 'ftp!' . pack('Cn', 64, 0), 'blocks';
 
 ok $ftp->quit(), 'quit';
 done_testing;
 `
 
 	perl.Prove(ctx, t, script, u.Host)
+
+	r, err := fs.Open(ctx, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if string(b) != "Hello ftp!" {
+		t.Errorf("want Hello ftp!, got %s", b)
+	}
+	if _, err := fs.Stat(ctx, "bar.txt"); !os.IsNotExist(err) {
+		t.Errorf("want not exist, got %v", err)
+	}
 }
 
-func TestEprt(t *testing.T) {
+func TestRest(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	// REST is disabled by default.
+	c := ts.Dial(t)
+	c.Login("anonymous", "foobar@example.com")
+	if msg := c.Cmd(211, "FEAT"); strings.Contains(msg, " REST ") {
+		t.Errorf("REST is in FEAT: %q", msg)
+	}
+	c.Cmd(502, "REST 100")
+	c.Cmd(502, "RANG 0 10")
+	c.Close()
+
+	ts = ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableRestart = true
+	ts.Start()
+	defer ts.Close()
+
+	c = ts.Dial(t)
+	defer c.Close()
+	c.Login("anonymous", "foobar@example.com")
+	if msg := c.Cmd(211, "FEAT"); !strings.Contains(msg, "\n REST STREAM\n") {
+		t.Errorf("REST is not in FEAT: %q", msg)
+	}
+
+	// the offsets don't match the files in ASCII type and MODE Z.
+	c.Cmd(200, "TYPE A")
+	c.Cmd(504, "REST 100")
+	c.Cmd(504, "RANG 0 10")
+	c.Cmd(200, "TYPE I")
+	c.Cmd(350, "REST 100")
+	c.Cmd(200, "MODE Z")
+	c.Cmd(504, "REST 100")
+	c.Cmd(504, "RANG 0 10")
+	c.Cmd(200, "MODE B")
+	c.Cmd(350, "REST 100")
+	c.Cmd(501, "REST -1")
+}
+
+func TestModeDeflate(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -900,9 +1088,8 @@ func TestEprt(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
-		"testfile": "Hello ftp!",
-	}))
+	fs := mapfs.New(map[string]string{})
+	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
 	ts.Start()
 	defer ts.Close()
@@ -917,25 +1104,49 @@ use strict;
 use warnings;
 use Test::More;
 use Net::FTP;
+use Compress::Zlib;
 
 my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
-
-if (!$ftp->can('eprt')) {
-	plan skip_all => 'eprt is not support';
+ok $ftp->binary, 'binary';
+ok grep(/^MODE Z$/, $ftp->feature('MODE')), 'feat';
+is $ftp->quot('OPTS', 'MODE Z LEVEL 9'), 2, 'OPTS MODE Z LEVEL';
+is $ftp->quot('OPTS', 'MODE Z LEVEL 10'), 5, 'invalid level';
+is $ftp->quot('MODE', 'Z'), 2, 'MODE Z';
+
+my $conn = $ftp->stor('foo.txt') or die "fail to stor";
+my $data = compress('Hello ftp!' x 100);
+$conn->write($data, length $data);
+ok $conn->close, 'stor';
+like $ftp->message, qr/received 1000 bytes \(\d+ bytes on the wire\)/, 'byte counts';
+
+$conn = $ftp->retr('foo.txt') or die "fail to retr";
+$data = '';
+my $buf;
+while ($conn->read($buf, 1024)) {
+	$data .= $buf;
 }
-ok $ftp->eprt(), 'eprt';
-ok my $fh = $ftp->retr('testfile'), 'retr';
-my $content = do { local $/ = ''; <$fh>};
-is $content, 'Hello ftp!', 'content';
+ok $conn->close, 'retr';
+is uncompress($data), 'Hello ftp!' x 100, 'content';
+
 ok $ftp->quit(), 'quit';
 done_testing;
 `
+
 	perl.Prove(ctx, t, script, u.Host)
+
+	r, err := fs.Open(ctx, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if string(b) != strings.Repeat("Hello ftp!", 100) {
+		t.Errorf("want Hello ftp! x 100, got %s", b)
+	}
 }
 
-func TestLang(t *testing.T) {
+func TestTypeASCII(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -945,7 +1156,10 @@ func TestLang(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	fs := mapfs.New(map[string]string{
+		"foo.txt": "Hello\nftp!\n",
+	})
+	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
 	ts.Start()
 	defer ts.Close()
@@ -955,6 +1169,8 @@ func TestLang(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Net::FTP converts line endings by itself in ASCII mode,
+	// so it sends TYPE A by quot after switching to binary mode to see the raw data.
 	script := `use utf8;
 use strict;
 use warnings;
@@ -963,15 +1179,43 @@ use Net::FTP;
 
 my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
-is $ftp->quot('LANG', 'en'), 2, 'English is supported';
-is $ftp->quot('LANG', 'ja'), 5, 'Japanese is not supported';
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+ok $ftp->binary, 'binary';
+is $ftp->quot('TYPE', 'A'), 2, 'TYPE A';
+ok !$ftp->size('foo.txt'), 'SIZE is refused';
+is $ftp->code, 550, 'SIZE is refused';
+
+my $conn = $ftp->retr('foo.txt') or die "fail to retr";
+my $data = '';
+my $buf;
+while ($conn->read($buf, 1024)) {
+	$data .= $buf;
+}
+ok $conn->close, 'retr';
+is $data, "Hello\r\nftp!\r\n", 'LF is converted to CRLF';
+
+$conn = $ftp->stor('bar.txt') or die "fail to stor";
+$data = "Hello\r\nftp!\r\n";
+$conn->write($data, length $data);
+ok $conn->close, 'stor';
+
 ok $ftp->quit(), 'quit';
 done_testing;
 `
+
 	perl.Prove(ctx, t, script, u.Host)
+
+	r, err := fs.Open(ctx, "bar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if string(b) != "Hello\nftp!\n" {
+		t.Errorf("want %q, got %q", "Hello\nftp!\n", b)
+	}
 }
 
-func TestMdtm(t *testing.T) {
+func TestTypeASCII_PassThrough(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -981,10 +1225,12 @@ func TestMdtm(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
-		"foobar.txt": "hello",
-	}))
+	fs := mapfs.New(map[string]string{
+		"foo.txt": "Hello\nftp!\n",
+	})
+	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
+	ts.Config.ASCIIPassThrough = true
 	ts.Start()
 	defer ts.Close()
 
@@ -1002,15 +1248,134 @@ use Net::FTP;
 my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
-ok my $mdtm = $ftp->mdtm('foobar.txt'), 'MDTM';
-is $mdtm, -62135596800, 'January 1, year 1, 00:00:00.000000000 UTC';
+ok $ftp->binary, 'binary';
+is $ftp->quot('TYPE', 'A'), 2, 'TYPE A';
+is $ftp->size('foo.txt'), 11, 'SIZE';
+
+my $conn = $ftp->retr('foo.txt') or die "fail to retr";
+my $data = '';
+my $buf;
+while ($conn->read($buf, 1024)) {
+	$data .= $buf;
+}
+ok $conn->close, 'retr';
+is $data, "Hello\nftp!\n", 'not converted';
+
 ok $ftp->quit(), 'quit';
 done_testing;
 `
+
 	perl.Prove(ctx, t, script, u.Host)
 }
 
-func TestShutdown_DataTransfer(t *testing.T) {
+// attrFS records the attributes changed by SITE CHMOD, SITE UTIME and MFMT, etc.
+type attrFS struct {
+	vfs.FileSystem
+
+	mu     sync.Mutex
+	modes  map[string]os.FileMode
+	mtimes map[string]time.Time
+	ctimes map[string]time.Time
+}
+
+func newAttrFS(fs vfs.FileSystem) *attrFS {
+	return &attrFS{
+		FileSystem: fs,
+		modes:      map[string]os.FileMode{},
+		mtimes:     map[string]time.Time{},
+		ctimes:     map[string]time.Time{},
+	}
+}
+
+func (fs *attrFS) Chmod(ctx context.Context, name string, mode os.FileMode) error {
+	if _, err := fs.FileSystem.Stat(ctx, name); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.modes[name] = mode
+	return nil
+}
+
+func (fs *attrFS) Chtimes(ctx context.Context, name string, modTime, createTime time.Time) error {
+	if _, err := fs.FileSystem.Stat(ctx, name); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !modTime.IsZero() {
+		fs.mtimes[name] = modTime
+	}
+	if !createTime.IsZero() {
+		fs.ctimes[name] = createTime
+	}
+	return nil
+}
+
+func (fs *attrFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	stat, err := fs.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.stat(name, stat), nil
+}
+
+func (fs *attrFS) ReadDir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	list, err := fs.FileSystem.ReadDir(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for i, stat := range list {
+		list[i] = fs.stat(pathpkg.Join(name, stat.Name()), stat)
+	}
+	return list, nil
+}
+
+func (fs *attrFS) stat(name string, stat os.FileInfo) os.FileInfo {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = pathpkg.Clean("/" + name)
+	mtime, ok1 := fs.mtimes[name]
+	ctime, ok2 := fs.ctimes[name]
+	if !ok1 && !ok2 {
+		return stat
+	}
+	if !ok1 {
+		mtime = stat.ModTime()
+	}
+	return attrStat{stat, mtime, ctime}
+}
+
+type attrStat struct {
+	os.FileInfo
+	mtime time.Time
+	ctime time.Time
+}
+
+func (stat attrStat) ModTime() time.Time {
+	return stat.mtime
+}
+
+func (stat attrStat) CreateTime() time.Time {
+	return stat.ctime
+}
+
+// siteAuthorizer permits the user "admin" to execute all SITE subcommands, including WHO.
+// DO NOT USE in the production.
+type siteAuthorizer struct{}
+
+func (siteAuthorizer) Authorize(ctx context.Context, conn *ftp.ServerConn, user, password string) (*ftp.Authorization, error) {
+	auth, err := ftptest.Authorizer.Authorize(ctx, conn, user, password)
+	if err != nil {
+		return nil, err
+	}
+	if user == "admin" {
+		auth.SiteCommands = []string{"CHMOD", "HELP", "IDLE", "PING", "UTIME", "WHO"}
+	}
+	return auth, nil
+}
+
+func TestSite(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -1020,11 +1385,16 @@ func TestShutdown_DataTransfer(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	fs := delayedFS{mapfs.New(map[string]string{
-		"testfile": "Hello ftp!",
-	})}
+	fs := newAttrFS(mapfs.New(map[string]string{
+		"foo.txt": "foo",
+	}))
 	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
+	ts.Config.IdleTimeout = time.Hour
+	ts.Config.Authorizer = siteAuthorizer{}
+	ts.Config.HandleSite("PING", ftp.SiteCommandFunc(func(ctx context.Context, c *ftp.ServerConn, cmd *ftp.Command) {
+		c.WriteReply(ftp.StatusCommandOK, "PONG "+cmd.Arg)
+	}))
 	ts.Start()
 	defer ts.Close()
 
@@ -1033,16 +1403,6 @@ func TestShutdown_DataTransfer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// call the Shutdown method during the tests,
-	shutdownRes := make(chan error, 1)
-	go func() {
-		time.Sleep(time.Second)
-		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
-		shutdownRes <- ts.Config.Shutdown(ctx)
-	}()
-
-	// but the tests will succeed, because the Shutdown is graceful.
 	script := `use utf8;
 use strict;
 use warnings;
@@ -1053,22 +1413,51 @@ my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
 
-my $result = "";
-open my $fh, ">", \$result;
-ok $ftp->get('testfile', $fh), 'get'; # it takes 2 seconds.
-is $result, "Hello ftp!";
+is $ftp->site('CHMOD', '600', 'foo.txt'), 2, 'SITE CHMOD';
+is $ftp->site('CHMOD', '600', 'bar.txt'), 5, 'SITE CHMOD no such file';
+is $ftp->site('CHMOD', '999', 'foo.txt'), 5, 'SITE CHMOD invalid mode';
+is $ftp->site('UTIME', '20200102030405', 'foo.txt'), 2, 'SITE UTIME';
+is $ftp->site('UTIME', 'foo.txt', '20200102030405', '20200102030406', '20200102030405', 'UTC'), 2, 'SITE UTIME with atime, mtime and ctime';
+is $ftp->site('UTIME', 'invalid', 'foo.txt'), 5, 'SITE UTIME invalid time';
+is $ftp->site('PING', 'hello'), 2, 'SITE PING';
+is $ftp->message, "PONG hello\n", 'SITE PING';
+
+is $ftp->site('HELP'), 2, 'SITE HELP';
+my $help = $ftp->message;
+like $help, qr/^ CHMOD$/m, 'SITE HELP CHMOD';
+like $help, qr/^ PING$/m, 'SITE HELP PING';
+like $help, qr/^ UTIME$/m, 'SITE HELP UTIME';
+unlike $help, qr/^ WHO$/m, 'SITE HELP WHO';
+is $ftp->site('WHO'), 5, 'SITE WHO is not permitted by default';
+
+my $admin = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $admin->login('admin', 'admin'), 'login as admin';
+is $admin->site('WHO'), 2, 'SITE WHO';
+my $who = $admin->message;
+like $who, qr/^ \S+ anonymous 127\.0\.0\.1 idle /m, 'SITE WHO';
+like $who, qr/^ \S+ admin 127\.0\.0\.1 idle /m, 'SITE WHO';
+ok $admin->quit(), 'quit admin';
+
+is $ftp->site('IDLE'), 2, 'SITE IDLE';
+is $ftp->message, "Current idle time limit is 3600 seconds; max 3600 seconds.\n", 'SITE IDLE';
+is $ftp->site('IDLE', '7200'), 5, 'SITE IDLE too long';
+is $ftp->site('IDLE', '1'), 2, 'SITE IDLE 1';
 sleep 2;
-# do not call $ftp->quit(), but the connection will be closed by the Shutdown.
+ok !$ftp->pwd, 'idle timeout';
 done_testing;
 `
+
 	perl.Prove(ctx, t, script, u.Host)
 
-	if err := <-shutdownRes; err != nil {
-		t.Error(err)
+	if got, want := fs.modes["/foo.txt"], os.FileMode(0600); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if got, want := fs.mtimes["/foo.txt"], time.Date(2020, time.January, 2, 3, 4, 6, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want %v, got %v", want, got)
 	}
 }
 
-func TestShutdown_DuringExecutingCommand(t *testing.T) {
+func TestSite_Permission(t *testing.T) {
 	perl, err := newPerlExecutor()
 	if err != nil {
 		t.Skipf("perl is required for this test: %v", err)
@@ -1078,11 +1467,13 @@ func TestShutdown_DuringExecutingCommand(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	fs := delayedFS{mapfs.New(map[string]string{
-		"testfile": "Hello ftp!",
-	})}
+	fs := newAttrFS(mapfs.New(map[string]string{
+		"foo.txt": "foo",
+	}))
 	ts := ftptest.NewUnstartedServer(fs)
 	ts.Config.Logger = testLogger{t}
+	ts.Config.Authorizer = ftp.AnonymousAuthorizer
+	ts.Config.HandleSite("IDLE", nil)
 	ts.Start()
 	defer ts.Close()
 
@@ -1091,16 +1482,6 @@ func TestShutdown_DuringExecutingCommand(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// call the Shutdown method during the tests,
-	shutdownRes := make(chan error, 1)
-	go func() {
-		time.Sleep(time.Second)
-		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
-		shutdownRes <- ts.Config.Shutdown(ctx)
-	}()
-
-	// but the tests will succeed, because the Shutdown is graceful.
 	script := `use utf8;
 use strict;
 use warnings;
@@ -1111,15 +1492,1273 @@ my $host = shift;
 my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
 ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
 
-# shutdown while running size.
-ok $ftp->size('testfile'), 'size';
+is $ftp->site('WHO'), 5, 'SITE WHO is not permitted';
+is $ftp->site('CHMOD', '600', 'foo.txt'), 5, 'SITE CHMOD is not permitted';
+is $ftp->site('HELP'), 2, 'SITE HELP';
+unlike $ftp->message, qr/^ WHO$/m, 'SITE HELP WHO';
+is $ftp->site('IDLE'), 5, 'SITE IDLE is disabled';
+is $ftp->code, 500, 'SITE IDLE is disabled';
 
-is $ftp->quot('NOOP'), 5, 'control connection is closed.';
+ok $ftp->quit(), 'quit';
 done_testing;
 `
+
 	perl.Prove(ctx, t, script, u.Host)
 
-	if err := <-shutdownRes; err != nil {
+	if len(fs.modes) != 0 {
+		t.Errorf("want no change, got %v", fs.modes)
+	}
+}
+
+func TestMfmt(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := newAttrFS(mapfs.New(map[string]string{
+		"foo.txt": "foo",
+	}))
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+is $ftp->quot('FEAT'), 2, 'FEAT';
+like $ftp->message, qr/^ MFMT$/m, 'MFMT';
+like $ftp->message, qr/^ MFCT$/m, 'MFCT';
+like $ftp->message, qr/^ MFF Modify;Create;UNIX.mode;$/m, 'MFF';
+
+is $ftp->quot('MFMT', '20200102030405 foo.txt'), 2, 'MFMT';
+is $ftp->message, "Modify=20200102030405; foo.txt\n", 'MFMT';
+is $ftp->quot('MDTM', 'foo.txt'), 2, 'MDTM';
+is $ftp->message, "20200102030405\n", 'MDTM';
+is $ftp->quot('MLST', 'foo.txt'), 2, 'MLST';
+like $ftp->message, qr/Modify=20200102030405;/, 'MLST';
+
+is $ftp->quot('MFCT', '20190102030405 foo.txt'), 2, 'MFCT';
+is $ftp->message, "Create=20190102030405; foo.txt\n", 'MFCT';
+
+is $ftp->quot('MFF', 'Modify=20210102030405;UNIX.mode=0600; foo.txt'), 2, 'MFF';
+is $ftp->message, "Modify=20210102030405;UNIX.mode=0600; foo.txt\n", 'MFF';
+is $ftp->quot('MFF', 'UNIX.owner=root; foo.txt'), 5, 'unsupported fact';
+is $ftp->quot('MFF', 'Modify=foo; foo.txt'), 5, 'invalid time';
+
+is $ftp->quot('MFMT', '20200102030405 bar.txt'), 5, 'not found';
+is $ftp->quot('MFMT', 'foo.txt'), 5, 'syntax error';
+
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+
+	if got, want := fs.mtimes["/foo.txt"], time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if got, want := fs.ctimes["/foo.txt"], time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if got, want := fs.modes["/foo.txt"], os.FileMode(0600); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestRein(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foo/bar.txt": "hello",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+ok $ftp->cwd('foo'), 'CWD';
+ok $ftp->ascii, 'TYPE A';
+is $ftp->quot('MODE', 'Z'), 2, 'MODE Z';
+
+is $ftp->quot('REIN'), 2, 'REIN';
+is $ftp->code, 220, 'REIN';
+is $ftp->quot('PWD'), 5, 'not logged in';
+
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login again';
+is $ftp->pwd, '/', 'PWD';
+is $ftp->quot('STAT'), 2, 'STAT';
+like $ftp->message, qr/TYPE: Image, MODE: Stream/, 'the session is reset';
+
+ok $ftp->quit;
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestCcc(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableCCC = true
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := ftptest.NewClient(t, conn)
+	c.Cmd(220, "")
+	c.Cmd(530, "CCC")
+	c.Cmd(234, "AUTH TLS")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: "example.com",
+		RootCAs:    pool,
+	})
+	c = ftptest.NewClient(t, tlsConn)
+	c.Cmd(331, "USER anonymous")
+	c.Cmd(230, "PASS foobar@example.com")
+	c.Cmd(200, "CCC")
+
+	// shut down the TLS session.
+	if err := tlsConn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, tlsConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session continues in plaintext.
+	c = ftptest.NewClient(t, conn)
+	c.Cmd(257, "PWD")
+	c.Cmd(533, "CCC")
+	c.Cmd(221, "QUIT")
+}
+
+func TestCcc_Disabled(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	c.Login("anonymous", "foobar@example.com")
+	c.Cmd(534, "CCC")
+}
+
+func TestHost(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.VirtualHosts = map[string]*ftp.VirtualHost{
+		"ftp.example.com": {
+			FileSystem: mapfs.New(map[string]string{
+				"example.txt": "Hello example.com",
+			}),
+			Banner: "Welcome to example.com\nHave a nice day",
+		},
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	if msg := c.Cmd(220, ""); msg != "Service ready" {
+		t.Errorf("unexpected banner: %q", msg)
+	}
+	c.Cmd(504, "HOST unknown.example.com")
+	if msg := c.Cmd(220, "HOST FTP.Example.COM"); msg != "Welcome to example.com\nHave a nice day" {
+		t.Errorf("unexpected banner: %q", msg)
+	}
+	c.Cmd(331, "USER anonymous")
+	c.Cmd(503, "HOST ftp.example.com")
+	c.Cmd(230, "PASS foobar@example.com")
+	c.Cmd(213, "SIZE example.txt")
+
+	// REIN resets the virtual host.
+	c.Cmd(220, "REIN")
+	c.Cmd(331, "USER anonymous")
+	c.Cmd(230, "PASS foobar@example.com")
+	c.Cmd(550, "SIZE example.txt")
+	c.Cmd(221, "QUIT")
+}
+
+func TestHost_SNI(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.VirtualHosts = map[string]*ftp.VirtualHost{
+		"example.com": {
+			Banner: "Welcome to example.com",
+		},
+		"ftp.example.com": {
+			Banner: "Welcome to ftp.example.com",
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	conn, err := tls.Dial("tcp", u.Host, &tls.Config{
+		ServerName: "example.com",
+		RootCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ftptest.NewClient(t, conn)
+	defer c.Close()
+
+	if msg := c.Cmd(220, ""); msg != "Welcome to example.com" {
+		t.Errorf("unexpected banner: %q", msg)
+	}
+
+	// the host must match the server name of TLS.
+	c.Cmd(504, "HOST ftp.example.com")
+	c.Cmd(220, "HOST example.com")
+}
+
+func TestCharset(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"日本語.txt": "こんにちは",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.BaseContext = func(l net.Listener) context.Context {
+		return context.WithValue(context.Background(), ftp.CharsetContextKey, japanese.ShiftJIS)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	sjis := "\x93\xfa\x96\x7b\x8c\xea.txt" // "日本語.txt" in Shift_JIS
+
+	c.Login("anonymous", "foobar@example.com")
+
+	// the path names are sent in Shift_JIS.
+	c.Cmd(213, "SIZE %s", sjis)
+	c.Cmd(501, "SIZE \x93.txt")
+	if msg := c.Cmd(213, "MLST %s", sjis); !strings.HasSuffix(msg, " /"+sjis+"\nEnd.") {
+		t.Errorf("unexpected MLST reply: %q", msg)
+	}
+
+	// NLST sends the names in Shift_JIS.
+	data := c.Passive()
+	c.Cmd(150, "NLST")
+	list, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	if string(list) != sjis+"\r\n" {
+		t.Errorf("unexpected listing: %q", list)
+	}
+
+	// switch to UTF-8.
+	c.Cmd(200, "OPTS UTF8 ON")
+	c.Cmd(213, "SIZE 日本語.txt")
+	c.Cmd(200, "OPTS UTF8 OFF")
+	c.Cmd(213, "SIZE %s", sjis)
+	c.Cmd(221, "QUIT")
+}
+
+func TestCharset_IdleTimeout(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.IdleTimeout = 500 * time.Millisecond
+	ts.Config.BaseContext = func(l net.Listener) context.Context {
+		return context.WithValue(context.Background(), ftp.CharsetContextKey, japanese.ShiftJIS)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	timer := time.AfterFunc(5*time.Second, func() { c.Close() })
+	defer timer.Stop()
+
+	c.Login("anonymous", "foobar@example.com")
+
+	// the invalid line doesn't stop the idle timer.
+	c.Cmd(501, "SIZE \x93.txt")
+	c.Cmd(421, "")
+}
+
+func TestRetr_RelativePath(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"dir/foo.txt": "foo",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableRestart = true
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	c.Login("anonymous", "foobar@example.com")
+	c.Cmd(200, "CWD dir")
+
+	retr := func(name string, offset int) string {
+		t.Helper()
+		data := c.Passive()
+		defer data.Close()
+		if offset > 0 {
+			c.Cmd(350, "REST %d", offset)
+		}
+		c.Cmd(150, "RETR %s", name)
+		got, err := io.ReadAll(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Cmd(226, "")
+		return string(got)
+	}
+
+	// the paths are relative to the current directory.
+	if got := retr("foo.txt", 0); got != "foo" {
+		t.Errorf("want %q, got %q", "foo", got)
+	}
+	if got := retr("foo.txt", 1); got != "oo" {
+		t.Errorf("want %q, got %q", "oo", got)
+	}
+
+	data := c.Passive()
+	c.Cmd(150, "STOR bar.txt")
+	if _, err := io.WriteString(data, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	c.Cmd(200, "CWD /")
+	if got := retr("dir/bar.txt", 0); got != "bar" {
+		t.Errorf("want %q, got %q", "bar", got)
+	}
+}
+
+func TestAbor(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	c.Login("anonymous", "foobar@example.com")
+
+	// ABOR without any transfers.
+	c.Cmd(226, "ABOR")
+
+	c.Cmd(200, "TYPE I")
+	data := c.Passive()
+	defer data.Close()
+
+	// the upload never finishes, because the data connection is kept open.
+	c.Cmd(150, "STOR abort.txt")
+	if _, err := io.WriteString(data, "Hello ABOR"); err != nil {
+		t.Fatal(err)
+	}
+
+	// send ABOR with the Telnet "Interrupt Process" and "Synch" signals, like Net::FTP does.
+	// the IAC of the Synch is sent as urgent data, so it doesn't reach the server.
+	c.Cmd(426, "\xff\xf4\xf2ABOR")
+	c.Cmd(226, "")
+
+	// the file is not created.
+	c.Cmd(550, "SIZE abort.txt")
+	c.Cmd(221, "QUIT")
+}
+
+func TestMlst(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := newAttrFS(mapfs.New(map[string]string{
+		"foo/bar.txt": "hello",
+	}))
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+is $ftp->quot('FEAT'), 2, 'FEAT';
+like $ftp->message, qr/^ MLST Type\*;Size\*;Modify\*;Create;Perm\*;Unique;Media-Type;UNIX.mode;UNIX.owner;UNIX.group;$/m, 'default facts';
+
+is $ftp->quot('MLST', 'foo/bar.txt'), 2, 'MLST';
+like $ftp->message, qr/^ Type=file;Size=5;Modify=00010101000000;Perm=adfrw; \/foo\/bar.txt$/m, 'MLST';
+is $ftp->quot('MLST', 'foo'), 2, 'MLST directory';
+like $ftp->message, qr/^ Type=dir;Size=0;Modify=00010101000000;Perm=cmpdfel; \/foo$/m, 'MLST directory';
+
+is $ftp->quot('MFCT', '20200102030405 foo/bar.txt'), 2, 'MFCT';
+is $ftp->quot('OPTS', 'MLST Create;type;UNIX.mode;Unknown;'), 2, 'OPTS MLST';
+is $ftp->message, "MLST OPTS Type;Create;UNIX.mode;\n", 'OPTS MLST';
+is $ftp->quot('FEAT'), 2, 'FEAT';
+like $ftp->message, qr/^ MLST Type\*;Size;Modify;Create\*;Perm;Unique;Media-Type;UNIX.mode\*;UNIX.owner;UNIX.group;$/m, 'selected facts';
+is $ftp->quot('MLST', 'foo/bar.txt'), 2, 'MLST';
+like $ftp->message, qr/^ Type=file;Create=20200102030405;UNIX.mode=0644; \/foo\/bar.txt$/m, 'MLST';
+
+ok $ftp->binary;
+is_deeply [$ftp->_list_cmd('MLSD', 'foo')], [
+	'Type=cdir;UNIX.mode=0755; /foo',
+	'Type=pdir;UNIX.mode=0755; /',
+	'Type=file;Create=20200102030405;UNIX.mode=0644; bar.txt',
+], 'MLSD';
+ok !$ftp->_list_cmd('MLSD', 'foo/bar.txt'), 'MLSD on a file';
+
+is $ftp->quot('OPTS', 'MLST'), 2, 'no facts';
+is $ftp->quot('MLST', 'foo/bar.txt'), 2, 'MLST';
+like $ftp->message, qr/^  \/foo\/bar.txt$/m, 'MLST';
+
+ok $ftp->quit;
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestStou(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := mapfs.New(map[string]string{})
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+eval {
+	my $name1 = do {
+		my $content = "Hello ftp!";
+		open my $fh, "<", \$content;
+		ok my $name = $ftp->put_unique($fh), 'put_unique';
+		close $fh;
+		$name;
+	};
+	
+	my $name2 = do {
+		my $content = "Hello ftp!";
+		open my $fh, "<", \$content;
+		ok my $name = $ftp->put_unique($fh), 'put_unique';
+		close $fh;
+		$name;
+	};
+	
+	isnt $name1, $name2;
+};
+if ($@ =~ /Must specify remote filename with stream input/i) {
+	# put_unique doesn't work correctly on old version of Net::FTP.
+	plan skip_all => 'put_unique is not support';
+}
+
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestFeat(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := mapfs.New(map[string]string{})
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+ok $ftp->feature('EPRT'), 'EPRT is supported';
+ok !$ftp->feature('HOGEFUGA'), 'HOGEFUGA is not supported';
+
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestEprt(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"testfile": "Hello ftp!",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+if (!$ftp->can('eprt')) {
+	plan skip_all => 'eprt is not support';
+}
+ok $ftp->eprt(), 'eprt';
+ok my $fh = $ftp->retr('testfile'), 'retr';
+my $content = do { local $/ = ''; <$fh>};
+is $content, 'Hello ftp!', 'content';
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestLang(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+is $ftp->quot('LANG', 'en'), 2, 'English is supported';
+is $ftp->quot('LANG', 'ja'), 2, 'Japanese is supported';
+is $ftp->quot('LANG', 'fr'), 5, 'French is not supported';
+is $ftp->quot('LANG'), 2, 'return to the default language';
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestLang_Catalog(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.Messages = map[string]ftp.Catalog{
+		"en": {"Not logged in": "Please log in first"},
+		"ja": {"Good bye.": "またね。"},
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	c.Cmd(220, "")
+	if msg := c.Cmd(211, "FEAT"); !strings.Contains(msg, "\n LANG EN*;JA\n") {
+		t.Errorf("unexpected FEAT reply: %q", msg)
+	}
+
+	// the operator overrides the English message.
+	if msg := c.Cmd(530, "PWD"); msg != "Please log in first" {
+		t.Errorf("unexpected PWD reply: %q", msg)
+	}
+
+	// the primary language subtag matches.
+	if msg := c.Cmd(200, "LANG ja-JP"); msg != "言語を ja に変更しました" {
+		t.Errorf("unexpected LANG reply: %q", msg)
+	}
+	if msg := c.Cmd(211, "FEAT"); !strings.Contains(msg, "\n LANG EN;JA*\n") {
+		t.Errorf("unexpected FEAT reply: %q", msg)
+	}
+
+	// the built-in catalog has priority over the English messages of the operator.
+	if msg := c.Cmd(530, "PWD"); msg != "ログインしていません" {
+		t.Errorf("unexpected PWD reply: %q", msg)
+	}
+	if msg := c.Cmd(331, "USER anonymous"); msg != "ユーザー名を確認しました。パスワードを入力してください。" {
+		t.Errorf("unexpected USER reply: %q", msg)
+	}
+	c.Cmd(230, "PASS foobar@example.com")
+	if msg := c.Cmd(257, "MKD foo"); msg != `"/foo" ディレクトリを作成しました。` {
+		t.Errorf("unexpected MKD reply: %q", msg)
+	}
+
+	c.Cmd(504, "LANG fr")
+	if msg := c.Cmd(221, "QUIT"); msg != "またね。" {
+		t.Errorf("unexpected QUIT reply: %q", msg)
+	}
+}
+
+func TestLprt(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foo.txt": "hello",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableActiveMode = true
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	c.Login("anonymous", "foobar@example.com")
+
+	// long passive mode
+	msg := c.Cmd(228, "LPSV")
+	var h1, h2, h3, h4, p1, p2 int
+	if _, err := fmt.Sscanf(msg[strings.Index(msg, "("):], "(4,4,%d,%d,%d,%d,2,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		t.Fatal(err)
+	}
+	data, err := net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, p1<<8|p2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Cmd(150, "RETR foo.txt")
+	got, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	if string(got) != "hello" {
+		t.Errorf("want %q, got %q", "hello", string(got))
+	}
+
+	// long active mode
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	if port < 1024 {
+		t.Skip("the port for the data connection must be more than 1024")
+	}
+	c.Cmd(200, "LPRT 4,4,127,0,0,1,2,%d,%d", port>>8, port&0xFF)
+	c.Cmd(150, "RETR foo.txt")
+	data, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	if string(got) != "hello" {
+		t.Errorf("want %q, got %q", "hello", string(got))
+	}
+
+	// protections against the bounce attack
+	c.Cmd(502, "LPRT 4,4,127,0,0,1,2,0,21")
+	c.Cmd(425, "LPRT 4,4,192,0,2,1,2,%d,%d", port>>8, port&0xFF)
+	c.Cmd(522, "LPRT 5,4,127,0,0,1,2,%d,%d", port>>8, port&0xFF)
+	c.Cmd(501, "LPRT 4,4,127,0,0,1")
+
+	// EPSV ALL disables the other commands.
+	c.Cmd(220, "EPSV ALL")
+	c.Cmd(501, "LPSV")
+	c.Cmd(501, "LPRT 4,4,127,0,0,1,2,%d,%d", port>>8, port&0xFF)
+	c.Cmd(501, "PORT 127,0,0,1,%d,%d", port>>8, port&0xFF)
+}
+
+func TestMessages(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foo/.message": "This is foo.\r\nBe careful.\r\n",
+		"bar/baz.txt":  "",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.Banner = "Authorized users only.\nAll activity is logged."
+	ts.Config.LoginMessage = template.Must(template.New("login").Parse("Welcome {{.User}} via {{.Listener}}\n{{if .Host}}{{.Host}}{{else}}no host{{end}}\n"))
+	ts.Config.DirMessage = ".message"
+	ts.Config.BaseContext = func(l net.Listener) context.Context {
+		return context.WithValue(context.Background(), ftp.ListenerNameContextKey, "test-listener")
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	if msg := c.Cmd(220, ""); msg != "Authorized users only.\nAll activity is logged." {
+		t.Errorf("unexpected banner: %q", msg)
+	}
+	c.Cmd(331, "USER anonymous")
+	if msg := c.Cmd(230, "PASS foobar@example.com"); msg != "Welcome anonymous via test-listener\nno host\nUser logged in, proceed." {
+		t.Errorf("unexpected login message: %q", msg)
+	}
+	if msg := c.Cmd(200, "CWD foo"); msg != "This is foo.\nBe careful.\nDirectory changed to /foo." {
+		t.Errorf("unexpected CWD reply: %q", msg)
+	}
+	if msg := c.Cmd(200, "CDUP"); msg != "Directory changed to /." {
+		t.Errorf("unexpected CDUP reply: %q", msg)
+	}
+	if msg := c.Cmd(200, "CWD bar"); msg != "Directory changed to /bar." {
+		t.Errorf("unexpected CWD reply: %q", msg)
+	}
+}
+
+type quotaAuthorizer struct{}
+
+func (quotaAuthorizer) Authorize(ctx context.Context, conn *ftp.ServerConn, user, password string) (*ftp.Authorization, error) {
+	auth := &ftp.Authorization{
+		User:       user,
+		FileSystem: conn.Server().FileSystem,
+	}
+	if user == "alice" {
+		auth.Quota = &ftp.Quota{Used: 1024, Limit: 4096}
+	}
+	return auth, nil
+}
+
+func TestMessages_Quota(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.Authorizer = quotaAuthorizer{}
+	ts.Config.LoginMessage = template.Must(template.New("login").Parse("{{with .Quota}}{{.Used}}/{{.Limit}} bytes used{{else}}no quota{{end}}\n"))
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	c.Cmd(220, "")
+	c.Cmd(331, "USER alice")
+	if msg := c.Cmd(230, "PASS alice"); msg != "1024/4096 bytes used\nUser logged in, proceed." {
+		t.Errorf("unexpected login message: %q", msg)
+	}
+	c.Cmd(220, "REIN")
+	c.Cmd(331, "USER bob")
+	if msg := c.Cmd(230, "PASS bob"); msg != "no quota\nUser logged in, proceed." {
+		t.Errorf("unexpected login message: %q", msg)
+	}
+}
+
+func TestMessages_Listener(t *testing.T) {
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foo/.message": "This is foo.",
+		"foo/README":   "This is README.",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.Banner = "Welcome to the server."
+	ts.Config.DirMessage = ".message"
+	ts.Config.VirtualHosts = map[string]*ftp.VirtualHost{
+		"example.com": {
+			Banner:     "Welcome to example.com.",
+			DirMessage: "README",
+		},
+	}
+	ts.Config.BaseContext = func(l net.Listener) context.Context {
+		ctx := context.WithValue(context.Background(), ftp.BannerContextKey, "Welcome to the listener.")
+		return context.WithValue(ctx, ftp.LoginMessageContextKey, template.Must(template.New("login").Parse("Hello {{.User}}")))
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+
+	// the listener has priority over the server.
+	if msg := c.Cmd(220, ""); msg != "Welcome to the listener." {
+		t.Errorf("unexpected banner: %q", msg)
+	}
+
+	// the virtual host has priority over the listener.
+	if msg := c.Cmd(220, "HOST example.com"); msg != "Welcome to example.com." {
+		t.Errorf("unexpected HOST reply: %q", msg)
+	}
+	c.Cmd(331, "USER anonymous")
+	if msg := c.Cmd(230, "PASS foobar@example.com"); msg != "Hello anonymous\nUser logged in, proceed." {
+		t.Errorf("unexpected login message: %q", msg)
+	}
+	if msg := c.Cmd(200, "CWD foo"); msg != "This is README.\nDirectory changed to /foo." {
+		t.Errorf("unexpected CWD reply: %q", msg)
+	}
+}
+
+func TestFXP(t *testing.T) {
+	// the peer of the data connection is another host on the loopback network.
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 is not available: %v", err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	_, peers, err := net.ParseCIDR("127.0.0.2/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foo.txt": "hello",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableActiveMode = true
+	ts.Config.FXPRules = []ftp.FXPRule{
+		{User: "ftp", Peers: []*net.IPNet{peers}, Mode: ftp.FXPActive},
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// the rule doesn't permit anonymous.
+	c := ts.Dial(t)
+	c.Login("anonymous", "foobar@example.com")
+	c.Cmd(425, "PORT 127,0,0,2,%d,%d", port>>8, port&0xFF)
+	c.Close()
+
+	// the rule permits ftp to make the data connection with 127.0.0.2.
+	c = ts.Dial(t)
+	defer c.Close()
+	c.Login("ftp", "foobar@example.com")
+	c.Cmd(200, "PORT 127,0,0,2,%d,%d", port>>8, port&0xFF)
+	c.Cmd(150, "RETR foo.txt")
+	data, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	if string(got) != "hello" {
+		t.Errorf("want %q, got %q", "hello", string(got))
+	}
+
+	// the rule is only for active mode.
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	data, err = dialer.Dial("tcp", c.PassiveAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+
+	// the server closes the connection from 127.0.0.2.
+	data.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := data.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("the data connection from 127.0.0.2 is accepted: %v", err)
+	}
+}
+
+func TestMdtm(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foobar.txt": "hello",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+ok my $mdtm = $ftp->mdtm('foobar.txt'), 'MDTM';
+is $mdtm, -62135596800, 'January 1, year 1, 00:00:00.000000000 UTC';
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestXsha256(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foobar.txt": "Hello ftp!",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+is $ftp->quot('XSHA256', 'foobar.txt'), 2, 'XSHA256';
+like $ftp->message(), qr/^7c721dc92950772ba4f563a43f1a56769de420ceca6b038deb93c53b47295f5e$/, 'checksum';
+is $ftp->quot('XSHA256', 'not-found.txt'), 5, 'not found';
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestHash(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts := ftptest.NewUnstartedServer(mapfs.New(map[string]string{
+		"foobar.txt":  "Hello ftp!",
+		"dir/foo.txt": "foo",
+	}))
+	ts.Config.Logger = testLogger{t}
+	ts.Config.EnableRestart = true
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+use IO::Socket::INET;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+is $ftp->quot('FEAT'), 2, 'FEAT';
+like $ftp->message, qr/^ HASH CRC32;CRC32C;MD5;SHA-1;SHA-256\*;SHA-512$/m, 'SHA-256 is selected';
+
+is $ftp->quot('HASH', 'foobar.txt'), 2, 'HASH';
+is $ftp->message, "SHA-256 0-9 7c721dc92950772ba4f563a43f1a56769de420ceca6b038deb93c53b47295f5e foobar.txt\n", 'SHA-256';
+
+is $ftp->quot('OPTS', 'HASH'), 2, 'OPTS HASH';
+is $ftp->message, "SHA-256\n", 'current algorithm';
+is $ftp->quot('OPTS', 'HASH md5'), 2, 'OPTS HASH MD5';
+is $ftp->message, "MD5\n", 'selected algorithm';
+is $ftp->quot('OPTS', 'HASH FOO'), 5, 'unknown algorithm';
+is $ftp->quot('FEAT'), 2, 'FEAT';
+like $ftp->message, qr/^ HASH CRC32;CRC32C;MD5\*;SHA-1;SHA-256;SHA-512$/m, 'MD5 is selected';
+
+is $ftp->quot('RANG', '6 100'), 3, 'RANG';
+is $ftp->quot('HASH', 'foobar.txt'), 2, 'HASH with range';
+is $ftp->message, "MD5 6-9 d67d4caaa569ab049fecf8e7d3dab44e foobar.txt\n", 'MD5 of the range';
+is $ftp->quot('HASH', 'foobar.txt'), 2, 'the range is reset';
+is $ftp->message, "MD5 0-9 752c095a688c477558c31387371999b0 foobar.txt\n", 'MD5 of the whole file';
+is $ftp->quot('RANG', '10 100'), 3, 'RANG';
+is $ftp->quot('HASH', 'foobar.txt'), 5, 'out of range';
+is $ftp->quot('RANG', '5 1'), 5, 'invalid range';
+
+is $ftp->quot('HASH', 'dir'), 5, 'directory';
+is $ftp->quot('HASH', 'not-found.txt'), 5, 'not found';
+
+is $ftp->quot('XMD5', 'foobar.txt'), 2, 'XMD5';
+is $ftp->message, "752c095a688c477558c31387371999b0\n", 'XMD5';
+is $ftp->quot('XMD5', '"foobar.txt" 0 5'), 2, 'XMD5 with range';
+is $ftp->message, "8b1a9953c4611296a827abf8c47804d7\n", 'XMD5 with range';
+is $ftp->quot('XSHA1', 'foobar.txt'), 2, 'XSHA1';
+is $ftp->message, "52854a0cd6f23f4db24e25a60863240752a857fc\n", 'XSHA1';
+is $ftp->quot('XCRC', 'foobar.txt'), 2, 'XCRC';
+is $ftp->message, "a5c1ebdc\n", 'XCRC';
+
+# RANG for RETR, it must be sent immediately before RETR.
+ok $ftp->binary, 'binary';
+ok $ftp->pasv, 'pasv';
+my @addr = $ftp->message =~ /(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)/;
+is $ftp->quot('RANG', '2 4'), 3, 'RANG';
+$ftp->command('RETR', 'foobar.txt');
+my $sock = IO::Socket::INET->new(
+	PeerAddr => join('.', @addr[0..3]),
+	PeerPort => $addr[4] * 256 + $addr[5],
+) or die "fail to connect data connection: $@";
+is $ftp->response, 1, 'retr';
+my $data = do { local $/; <$sock> };
+close $sock;
+is $ftp->response, 2, 'retr';
+is $data, 'llo', 'the range of the file';
+
+ok $ftp->quit(), 'quit';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+}
+
+func TestShutdown_DataTransfer(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := delayedFS{mapfs.New(map[string]string{
+		"testfile": "Hello ftp!",
+	})}
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// call the Shutdown method during the tests,
+	shutdownRes := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		shutdownRes <- ts.Config.Shutdown(ctx)
+	}()
+
+	// but the tests will succeed, because the Shutdown is graceful.
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+my $result = "";
+open my $fh, ">", \$result;
+ok $ftp->get('testfile', $fh), 'get'; # it takes 2 seconds.
+is $result, "Hello ftp!";
+sleep 2;
+# do not call $ftp->quit(), but the connection will be closed by the Shutdown.
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+
+	if err := <-shutdownRes; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShutdown_DuringExecutingCommand(t *testing.T) {
+	perl, err := newPerlExecutor()
+	if err != nil {
+		t.Skipf("perl is required for this test: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fs := delayedFS{mapfs.New(map[string]string{
+		"testfile": "Hello ftp!",
+	})}
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Start()
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// call the Shutdown method during the tests,
+	shutdownRes := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		shutdownRes <- ts.Config.Shutdown(ctx)
+	}()
+
+	// but the tests will succeed, because the Shutdown is graceful.
+	script := `use utf8;
+use strict;
+use warnings;
+use Test::More;
+use Net::FTP;
+
+my $host = shift;
+my $ftp = Net::FTP->new($host, Debug => 1) or die "fail to connect ftp server: $@";
+ok $ftp->login('anonymous', 'foobar@example.com'), 'login';
+
+# shutdown while running size.
+ok $ftp->size('testfile'), 'size';
+
+is $ftp->quot('NOOP'), 5, 'control connection is closed.';
+done_testing;
+`
+	perl.Prove(ctx, t, script, u.Host)
+
+	if err := <-shutdownRes; err != nil {
 		t.Error(err)
 	}
 }
@@ -1180,6 +2819,25 @@ done_testing;
 	}
 }
 
+func TestHash_IdleTimeout(t *testing.T) {
+	fs := delayedFS{mapfs.New(map[string]string{
+		"testfile": "Hello ftp!",
+	})}
+	ts := ftptest.NewUnstartedServer(fs)
+	ts.Config.Logger = testLogger{t}
+	ts.Config.IdleTimeout = 500 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	c.Login("anonymous", "foobar@example.com")
+
+	// hashing takes longer than the idle timeout, but the connection is kept.
+	c.Cmd(250, "XCRC testfile")
+	c.Cmd(200, "NOOP")
+}
+
 type delayedFS struct {
 	vfs.FileSystem
 }
@@ -4,15 +4,18 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
-	"os"
 	pkgpath "path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shogo82148/s3ftpgateway/vfs"
+	"golang.org/x/text/encoding"
 )
 
 type protectionLevel byte
@@ -24,19 +27,23 @@ const (
 	protectionLevelPrivate                      = 'P'
 )
 
+// the maximum amount of time to wait for close_notify from the client after CCC command.
+const cccTimeout = 10 * time.Second
+
 // ServerConn is a connection of the ftp server.
 type ServerConn struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	server    *Server
 	tlsConfig *tls.Config
-	sessionID string
 
 	// connection for control
 	mu      sync.Mutex
 	rwc     net.Conn
 	ctrl    *dumbTelnetConn
 	scanner *bufio.Scanner
+	charset encoding.Encoding // the charset of the control connection. nil means UTF-8.
+	lang    string            // the language of the reply messages, selected by LANG command.
 
 	executing    atomicBool
 	shuttingDown atomicBool
@@ -51,20 +58,80 @@ type ServerConn struct {
 	// pwd is current working directory.
 	pwd string
 
+	// the name of the virtual host, selected by HOST command or SNI.
+	host string
+
+	// the name of the virtual host selected by SNI.
+	// it is kept by REIN command, same as the TLS session.
+	sniHost string
+
 	// TLS connection is enabled.
 	tls bool
 
+	// the TLS session of the control connection, set by AUTH TLS command.
+	tlsConn *tls.Conn
+
+	// the control connection is cleared by CCC command.
+	// the data connections are still protected.
+	ccc bool
+
 	// data channel protection level
 	prot protectionLevel
 
+	// the state of OPTS UTF8 command.
+	utf8 utf8Option
+
+	// TYPE A is selected.
+	ascii bool
+
+	// data transfer mode
+	mode transferMode
+
+	// the compression level for MODE Z
+	deflateLevel int
+
+	// the offset for restarting the next transfer, set by REST command.
+	restart int64
+
+	// the byte range for the next command, set by RANG command.
+	byteRange *byteRange
+
+	// the algorithm of HASH command, set by OPTS HASH command.
+	hashAlgorithm string
+
+	// the facts of MLST and MLSD commands, set by OPTS MLST command.
+	// nil means the default facts.
+	mlstFacts []string
+
 	// for RNFR command.
-	rmfr       string
-	rmfrReader io.ReadCloser
+	rmfr     string
+	rmfrETag string
 
 	// a connector for data connection
 	mudt sync.Mutex // guard dt
 	dt   dataTransfer
 
+	// the total size of the data transferred in the session, for STAT command.
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+
+	// the data transfers running in the background.
+	// running counts them for the idle timeout, because some of them have no data connection, e.g. HASH.
+	transfers sync.WaitGroup
+	running   atomic.Int32
+
+	// cancels the context of the current data transfer.
+	mutr           sync.Mutex // guard cancelTransfer
+	cancelTransfer context.CancelFunc
+
+	// the activity of the session for SITE WHO and the idle timeout.
+	muidle      sync.Mutex // guard the following fields
+	sessionID   string
+	activeUser  string
+	lastActive  time.Time
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
 	// use EPSV command for starting data connection.
 	// if it is true, reject all data connection
 	// setup commands other than EPSV (i.e., EPRT, PORT, PASV, et al.)
@@ -76,26 +143,52 @@ func (c *ServerConn) Server() *Server {
 	return c.server
 }
 
+// ListenerName returns the name of the listener that accepted the connection.
+// It is set by Server.BaseContext with ListenerNameContextKey.
+func (c *ServerConn) ListenerName() string {
+	name, _ := c.ctx.Value(ListenerNameContextKey).(string)
+	return name
+}
+
+// session returns the identity of the session for the file system.
+func (c *ServerConn) session() *vfs.Session {
+	s := &vfs.Session{
+		ID:       c.getSessionID(),
+		Listener: c.ListenerName(),
+	}
+	if c.auth != nil {
+		s.User = c.auth.User
+	}
+	if ip := c.remoteIP(); ip != nil {
+		s.ClientIP = ip.String()
+	}
+	return s
+}
+
 func (c *ServerConn) tlsCfg() *tls.Config {
 	if c.tlsConfig != nil {
 		return c.tlsConfig
 	}
 	if c.server.TLSConfig != nil {
-		return c.server.TLSConfig
+		return c.server.tlsConfigWithHosts(c.server.TLSConfig)
 	}
 	return &tls.Config{}
 }
 
 func (c *ServerConn) serve() {
-	c.server.logger().Printf(c.sessionID, "a new connection from %s", c.rwc.RemoteAddr().String())
+	c.server.logger().Printf(c.getSessionID(), "a new connection from %s", c.rwc.RemoteAddr().String())
 
-	defer func() {
-		if c.rmfrReader != nil {
-			c.rmfrReader.Close()
+	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
+		// implicit TLS mode. the greeting depends on the virtual host selected by SNI.
+		if err := tlsConn.HandshakeContext(c.ctx); err != nil {
+			c.server.logger().Printf(c.getSessionID(), "TLS handshake error: %v", err)
+			return
 		}
-	}()
+		c.selectHostByTLS(tlsConn)
+	}
 
-	c.WriteReply(StatusReady, "Service ready")
+	c.WriteReply(StatusReady, c.banner()...)
+	c.touch()
 
 	for !c.shuttingDown.isSet() && c.scanner.Scan() {
 		c.executing.setTrue()
@@ -104,28 +197,46 @@ func (c *ServerConn) serve() {
 			c.WriteReply(StatusNotAvailable, "Service not available, closing control connection.")
 			break
 		}
+		text, err := c.decodeCommand(text)
+		if err != nil {
+			c.WriteReply(StatusBadArguments, "Invalid character encoding.")
+			c.executing.setFalse()
+			continue
+		}
 		cmd, err := ParseCommand(text)
 		if err != nil {
 			c.WriteReply(StatusBadCommand, "Syntax error.")
+			c.executing.setFalse()
 			continue
 		}
 		c.execCommand(cmd)
 		c.executing.setFalse()
 	}
 	if err := c.scanner.Err(); err != nil {
-		c.server.logger().Printf(c.sessionID, "error reading the control connection: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "error reading the control connection: %v", err)
 	}
-	c.server.logger().Print(c.sessionID, "closing the connection")
+	c.server.logger().Print(c.getSessionID(), "closing the connection")
 }
 
 func (c *ServerConn) execCommand(cmd *Command) {
-	ctx, cancel := context.WithTimeout(c.ctx, time.Minute)
+	c.touch()
+	defer c.touch()
+
+	ctx, cancel := context.WithTimeout(vfs.WithSession(c.ctx, c.session()), time.Minute)
 	defer cancel()
 
+	// REST and RANG commands are only effective for the command immediately following them.
+	if cmd.Name != "REST" && cmd.Name != "RANG" {
+		defer func() {
+			c.restart = 0
+			c.byteRange = nil
+		}()
+	}
+
 	if cmd.Name != "PASS" {
-		c.server.logger().PrintCommand(c.sessionID, cmd.Name, cmd.Arg)
+		c.server.logger().PrintCommand(c.getSessionID(), cmd.Name, cmd.Arg)
 	} else {
-		c.server.logger().PrintCommand(c.sessionID, cmd.Name, "****")
+		c.server.logger().PrintCommand(c.getSessionID(), cmd.Name, "****")
 	}
 
 	command, ok := commands[cmd.Name]
@@ -145,20 +256,42 @@ func (c *ServerConn) execCommand(cmd *Command) {
 }
 
 // WriteReply writes a ftp reply.
+// The messages are translated into the language of the connection, if the catalog has them.
 func (c *ServerConn) WriteReply(code int, messages ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendReply(code, c.localize(messages))
+}
+
+// WriteReplyf writes a single line ftp reply formatted according to the format specifier.
+// The format is translated into the language of the connection, if the catalog has it.
+func (c *ServerConn) WriteReplyf(code int, format string, a ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendReply(code, []string{fmt.Sprintf(c.message(format), a...)})
+}
+
+// sendReply writes the reply. c.mu must be held.
+func (c *ServerConn) sendReply(code int, messages []string) {
 	if len(messages) > 0 {
-		c.server.logger().PrintResponse(c.sessionID, code, messages[0])
+		c.server.logger().PrintResponse(c.getSessionID(), code, messages[0])
 	} else {
-		c.server.logger().PrintResponse(c.sessionID, code, "")
+		c.server.logger().PrintResponse(c.getSessionID(), code, "")
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	if _, err := c.writeReply(code, messages...); err != nil {
-		c.server.logger().Printf(c.sessionID, "error: %v", err)
+		c.server.logger().Printf(c.getSessionID(), "error: %v", err)
 	}
 }
 
 func (c *ServerConn) writeReply(code int, messages ...string) (int, error) {
+	if c.charset != nil {
+		encoded := make([]string, len(messages))
+		for i, msg := range messages {
+			encoded[i] = encodeString(c.charset, msg)
+		}
+		messages = encoded
+	}
+
 	if len(messages) == 0 {
 		n, err := fmt.Fprintf(c.ctrl, "%03d \r\n", code)
 		if err != nil {
@@ -181,9 +314,15 @@ func (c *ServerConn) writeReply(code int, messages ...string) (int, error) {
 	}
 
 	// multiple lines Reply
+	// the lines beginning with a space are sent without the code,
+	// e.g. the features of FEAT and the facts of MLST. see RFC 959 Section 4.2.
 	m := 0
 	for _, msg := range messages[:len(messages)-1] {
-		n, err := fmt.Fprintf(c.ctrl, "%03d-%s\r\n", code, msg)
+		format := "%03d-%s\r\n"
+		if strings.HasPrefix(msg, " ") {
+			format = "%[2]s\r\n"
+		}
+		n, err := fmt.Fprintf(c.ctrl, format, code, msg)
 		m += n
 		if err != nil {
 			return m, err
@@ -205,27 +344,93 @@ func (c *ServerConn) upgradeToTLS() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	tlsConn := tls.Server(c.rwc, c.server.TLSConfig)
+	var conn net.Conn = c.rwc
+	if c.server.EnableCCC {
+		// the plaintext commands may follow the TLS session, so keep them unread.
+		conn = &tlsRecordConn{Conn: conn}
+	}
+	tlsConn := tls.Server(conn, c.tlsCfg())
 	if err := tlsConn.Handshake(); err != nil {
 		return err
 	}
+	if c.user == "" && c.auth == nil {
+		c.selectHostByTLS(tlsConn)
+	}
 
-	c.ctrl = newDumbTelnetConn(tlsConn, tlsConn)
-	c.scanner = bufio.NewScanner(c.ctrl)
+	c.setControlConn(tlsConn)
 	c.tls = true
+	c.tlsConn = tlsConn
 
 	return nil
 }
 
-func (c *ServerConn) fileSystem() vfs.FileSystem {
-	fs := c.auth.FileSystem
-	if fs == nil {
-		fs = c.server.FileSystem
+// tlsRecordConn is a net.Conn that doesn't read beyond the end of the current TLS record.
+// tls.Conn reads ahead as much as possible, but the data after close_notify is plaintext
+// after the command channel is cleared by CCC command.
+type tlsRecordConn struct {
+	net.Conn
+
+	header [5]byte // the header of TLS records
+	n      int     // the number of bytes read in the header
+	remain int     // the number of bytes remaining in the current record
+}
+
+func (c *tlsRecordConn) Read(p []byte) (int, error) {
+	if c.remain == 0 {
+		// read the header of the next record.
+		if len(p) > len(c.header)-c.n {
+			p = p[:len(c.header)-c.n]
+		}
+		n, err := c.Conn.Read(p)
+		copy(c.header[c.n:], p[:n])
+		c.n += n
+		if c.n == len(c.header) {
+			c.n = 0
+			c.remain = int(binary.BigEndian.Uint16(c.header[3:]))
+		}
+		return n, err
+	}
+	if len(p) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.Conn.Read(p)
+	c.remain -= n
+	return n, err
+}
+
+// clearCommandChannel shuts down the TLS session of the control connection,
+// and continues the session in plaintext.
+func (c *ServerConn) clearCommandChannel() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// send close_notify, and wait for close_notify from the client.
+	if err := c.tlsConn.CloseWrite(); err != nil {
+		return err
 	}
-	if fs == nil {
-		fs = vfs.Null
+	if err := c.tlsConn.SetReadDeadline(time.Now().Add(cccTimeout)); err != nil {
+		return err
 	}
-	return fs
+	if _, err := io.Copy(io.Discard, c.tlsConn); err != nil {
+		return err
+	}
+	// tls.Conn.CloseWrite sets the write deadline to prevent further writes, so reset it too.
+	if err := c.rwc.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	c.setControlConn(c.rwc)
+	c.tlsConn = nil
+	c.ccc = true
+
+	return nil
+}
+
+func (c *ServerConn) fileSystem() vfs.FileSystem {
+	if fs := c.auth.FileSystem; fs != nil {
+		return fs
+	}
+	return c.server.ResolveHost(c).FileSystem
 }
 
 // Close closes all connections including the data transfer connection.
@@ -237,6 +442,11 @@ func (c *ServerConn) Close() error {
 
 func (c *ServerConn) close() {
 	c.shuttingDown.setTrue()
+	c.muidle.Lock()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.muidle.Unlock()
 	if err := c.closeDataTransfer(); err != nil && c.closeErr == nil {
 		c.closeErr = err
 	}
@@ -274,6 +484,163 @@ func (c *ServerConn) shutdown() error {
 	return nil
 }
 
+// touch records the activity of the session, and restarts the idle timer.
+func (c *ServerConn) touch() {
+	c.muidle.Lock()
+	defer c.muidle.Unlock()
+	c.lastActive = time.Now()
+	if c.auth != nil {
+		c.activeUser = c.auth.User
+	}
+	c.resetIdleTimerLocked()
+}
+
+func (c *ServerConn) resetIdleTimerLocked() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	if c.idleTimeout > 0 && !c.shuttingDown.isSet() {
+		c.idleTimer = time.AfterFunc(c.idleTimeout, c.idle)
+	}
+}
+
+// activity returns the session ID, the logged in user and the time of the last activity.
+func (c *ServerConn) activity() (id, user string, lastActive time.Time) {
+	c.muidle.Lock()
+	defer c.muidle.Unlock()
+	return c.sessionID, c.activeUser, c.lastActive
+}
+
+// getSessionID returns the ID of the session. It is changed by REIN command.
+func (c *ServerConn) getSessionID() string {
+	c.muidle.Lock()
+	defer c.muidle.Unlock()
+	return c.sessionID
+}
+
+func (c *ServerConn) getIdleTimeout() time.Duration {
+	c.muidle.Lock()
+	defer c.muidle.Unlock()
+	return c.idleTimeout
+}
+
+func (c *ServerConn) setIdleTimeout(timeout time.Duration) {
+	c.muidle.Lock()
+	defer c.muidle.Unlock()
+	c.idleTimeout = timeout
+	c.resetIdleTimerLocked()
+}
+
+// idle is called when the idle timer expires.
+func (c *ServerConn) idle() {
+	if c.executing.isSet() || c.running.Load() > 0 || c.transferring() {
+		// the client is waiting for the result.
+		c.muidle.Lock()
+		c.resetIdleTimerLocked()
+		c.muidle.Unlock()
+		return
+	}
+	c.server.logger().Print(c.getSessionID(), "idle timeout")
+	c.WriteReply(StatusNotAvailable, "Idle timeout, closing control connection.")
+	c.Close()
+}
+
+// transferring reports whether a data connection is in use.
+func (c *ServerConn) transferring() bool {
+	c.mudt.Lock()
+	defer c.mudt.Unlock()
+	_, ok := c.dt.(emptyDataTransfer)
+	return !ok
+}
+
+// reinitialize waits for the data transfers in progress,
+// and resets the session to the state before the login.
+// The TLS session of the control connection is kept, as described in RFC 4217.
+// A new session ID is assigned, so the logs of the users are not mixed up.
+func (c *ServerConn) reinitialize() {
+	c.transfers.Wait()
+	c.closeDataTransfer()
+
+	id := newSessionID()
+	c.server.logger().Printf(c.getSessionID(), "reinitializing the session, the new session id is %s", id)
+	c.muidle.Lock()
+	c.sessionID = id
+	c.activeUser = ""
+	c.idleTimeout = c.server.IdleTimeout
+	c.resetIdleTimerLocked()
+	c.muidle.Unlock()
+
+	c.user = ""
+	c.auth = nil
+	c.failCnt = 0
+	c.pwd = ""
+	c.host = c.sniHost
+	c.prot = protectionLevelClear
+	c.ascii = false
+	c.mode = transferModeStream
+	c.deflateLevel = defaultDeflateLevel
+	c.restart = 0
+	c.byteRange = nil
+	c.hashAlgorithm = vfs.HashSHA256
+	c.mlstFacts = nil
+	c.utf8 = utf8Default
+	c.updateCharset()
+	c.setLanguage(c.server.language())
+	c.rmfr = ""
+	c.rmfrETag = ""
+	c.epsvAll = false
+	c.bytesSent.Store(0)
+	c.bytesReceived.Store(0)
+}
+
+// setControlConn sets up the control connection on rw.
+// "Interrupt Process" of Telnet aborts the data transfer in progress, without waiting for ABOR command.
+func (c *ServerConn) setControlConn(rw io.ReadWriter) {
+	c.ctrl = newDumbTelnetConn(rw, rw)
+	c.ctrl.interrupt = c.abortTransfer
+	c.scanner = bufio.NewScanner(c.ctrl)
+}
+
+// startTransfer runs f in a new goroutine to transfer data in the background.
+func (c *ServerConn) startTransfer(f func()) {
+	c.transfers.Add(1)
+	c.running.Add(1)
+	go func() {
+		defer c.transfers.Done()
+		defer func() {
+			// the idle time starts at the end of the transfer.
+			c.muidle.Lock()
+			c.lastActive = time.Now()
+			c.resetIdleTimerLocked()
+			c.muidle.Unlock()
+			c.running.Add(-1)
+		}()
+		f()
+	}()
+}
+
+// newTransferContext returns a new context for a data transfer.
+// The context is canceled by ABOR, or when the connection is closed.
+// Canceling it aborts the operation of the file system, e.g. uploading the file.
+func (c *ServerConn) newTransferContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(vfs.WithSession(c.ctx, c.session()))
+	c.mutr.Lock()
+	defer c.mutr.Unlock()
+	c.cancelTransfer = cancel
+	return ctx, cancel
+}
+
+// abortTransfer cancels the context of the current data transfer.
+func (c *ServerConn) abortTransfer() {
+	c.mutr.Lock()
+	defer c.mutr.Unlock()
+	if c.cancelTransfer != nil {
+		c.cancelTransfer()
+		c.cancelTransfer = nil
+	}
+}
+
 func (c *ServerConn) publicIPv4() net.IP {
 	for _, s := range c.server.PublicIPs {
 		ip := net.ParseIP(s)
@@ -304,14 +671,3 @@ func (c *ServerConn) buildPath(path string) string {
 	}
 	return pkgpath.Clean(pkgpath.Join(c.pwd, path))
 }
-
-func (c *ServerConn) formatFileInfo(fi os.FileInfo) string {
-	return fmt.Sprintf(
-		"%s 1 %s %s %13d %s %s",
-		fi.Mode(),
-		c.auth.User, c.auth.User,
-		fi.Size(),
-		fi.ModTime().Format(" Jan _2 15:04"),
-		fi.Name(),
-	)
-}
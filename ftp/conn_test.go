@@ -1,6 +1,7 @@
 package ftp
 
 import (
+	"context"
 	"io/ioutil"
 	"net"
 	"testing"
@@ -12,7 +13,7 @@ func TestWriteReply(t *testing.T) {
 		s := &Server{
 			Logger: testLogger{t},
 		}
-		c := s.newConn(server, nil)
+		c := s.newConn(context.Background(), server, nil)
 		go func() {
 			c.WriteReply(200)
 			server.Close()
@@ -32,7 +33,7 @@ func TestWriteReply(t *testing.T) {
 		s := &Server{
 			Logger: testLogger{t},
 		}
-		c := s.newConn(server, nil)
+		c := s.newConn(context.Background(), server, nil)
 		go func() {
 			c.WriteReply(200, "Okay.")
 			server.Close()
@@ -52,7 +53,7 @@ func TestWriteReply(t *testing.T) {
 		s := &Server{
 			Logger: testLogger{t},
 		}
-		c := s.newConn(server, nil)
+		c := s.newConn(context.Background(), server, nil)
 		go func() {
 			c.WriteReply(200, "First line.", "Second line.", "Last line.")
 			server.Close()
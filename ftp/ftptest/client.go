@@ -0,0 +1,98 @@
+package ftptest
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// A Client is a client of the FTP control connection, for use in end-to-end FTP tests.
+// It sends raw commands and checks the codes of the replies,
+// so the tests can use the commands that the FTP client libraries don't support.
+type Client struct {
+	t    testing.TB
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// NewClient returns a new Client on the control connection conn.
+func NewClient(t testing.TB, conn net.Conn) *Client {
+	return &Client{
+		t:    t,
+		conn: conn,
+		text: textproto.NewConn(conn),
+	}
+}
+
+// Dial connects to the server.
+// The caller should call Close when finished.
+func (s *Server) Dial(t testing.TB) *Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(t, conn)
+}
+
+// Cmd sends the command and reads its reply.
+// If format is empty, Cmd only reads the reply, e.g. the greeting.
+// It fails the test if the code of the reply isn't code, and returns the message of the reply.
+func (c *Client) Cmd(code int, format string, args ...any) string {
+	c.t.Helper()
+	if format != "" {
+		if err := c.text.PrintfLine(format, args...); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	_, msg, err := c.text.ReadResponse(code)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// Login reads the greeting, and logs in as user.
+func (c *Client) Login(user, password string) {
+	c.t.Helper()
+	c.Cmd(220, "")
+	c.Cmd(331, "USER %s", user)
+	c.Cmd(230, "PASS %s", password)
+}
+
+// PassiveAddr enters extended passive mode by EPSV, and returns the address of the data connection.
+func (c *Client) PassiveAddr() string {
+	c.t.Helper()
+	msg := c.Cmd(229, "EPSV")
+	i := strings.Index(msg, "(|||")
+	if i < 0 {
+		c.t.Fatalf("unexpected EPSV reply: %q", msg)
+	}
+	var port int
+	if _, err := fmt.Sscanf(msg[i:], "(|||%d|)", &port); err != nil {
+		c.t.Fatal(err)
+	}
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// Passive enters extended passive mode by EPSV, and opens the data connection.
+// The caller should close the data connection when finished.
+func (c *Client) Passive() net.Conn {
+	c.t.Helper()
+	conn, err := net.Dial("tcp", c.PassiveAddr())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return conn
+}
+
+// Close closes the control connection.
+func (c *Client) Close() error {
+	return c.text.Close()
+}
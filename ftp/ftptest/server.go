@@ -25,7 +25,7 @@ type testAuthorizer struct{}
 func (testAuthorizer) Authorize(ctx context.Context, conn *ftp.ServerConn, user, password string) (*ftp.Authorization, error) {
 	return &ftp.Authorization{
 		User:       user,
-		FileSystem: conn.Server().FileSystem,
+		FileSystem: conn.Server().ResolveHost(conn).FileSystem,
 	}, nil
 }
 
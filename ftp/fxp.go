@@ -0,0 +1,94 @@
+package ftp
+
+import (
+	"net"
+)
+
+// FXPMode is the mode of the data connections that an FXPRule permits.
+type FXPMode int
+
+const (
+	// FXPAny permits the data connections in both active and passive modes.
+	FXPAny FXPMode = iota
+
+	// FXPActive permits the data connections in active mode, e.g. PORT command.
+	FXPActive
+
+	// FXPPassive permits the data connections in passive mode, e.g. PASV command.
+	FXPPassive
+)
+
+func (mode FXPMode) String() string {
+	switch mode {
+	case FXPActive:
+		return "active"
+	case FXPPassive:
+		return "passive"
+	}
+	return "any"
+}
+
+// An FXPRule permits the data connections with the peers other than the client,
+// i.e. server-to-server transfers (FXP).
+// By default, the peer of a data connection must be the client of the control connection,
+// to avoid the bounce attack. See RFC 2577.
+type FXPRule struct {
+	// User is the name of the user that the rule is applied to.
+	// If it is empty, the rule is applied to all users.
+	User string
+
+	// Peers are the networks of the permitted peers.
+	Peers []*net.IPNet
+
+	// Mode is the mode of the data connections that the rule permits.
+	Mode FXPMode
+}
+
+func (rule *FXPRule) match(user string, ip net.IP, mode FXPMode) bool {
+	if rule.User != "" && rule.User != user {
+		return false
+	}
+	if rule.Mode != FXPAny && rule.Mode != mode {
+		return false
+	}
+	for _, peer := range rule.Peers {
+		if peer.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedUser returns the name of the user logged in.
+// It returns an empty string before login.
+func (c *ServerConn) authorizedUser() string {
+	if c.auth == nil {
+		return ""
+	}
+	return c.auth.User
+}
+
+// permitsDataPeer reports whether the user can make a data connection with ip in the mode.
+// The client of the control connection is always permitted,
+// and the other peers are permitted by Server.FXPRules.
+func (c *ServerConn) permitsDataPeer(user string, ip net.IP, mode FXPMode) bool {
+	if c.server.DisableAddressCheck {
+		return true
+	}
+	ctrl := c.remoteIP()
+	if ctrl == nil {
+		return false
+	}
+	if ip.Equal(ctrl) {
+		return true
+	}
+
+	for i := range c.server.FXPRules {
+		if c.server.FXPRules[i].match(user, ip, mode) {
+			c.server.logger().Printf(c.getSessionID(), "FXP: user %s makes a data connection in %s mode with %s, the client is %s", user, mode, ip, ctrl)
+			return true
+		}
+	}
+	c.server.logger().Printf(c.getSessionID(), "the data connection in %s mode with %s is rejected, the client is %s", mode, ip, ctrl)
+	return false
+}
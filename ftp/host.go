@@ -0,0 +1,169 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"strings"
+	"text/template"
+
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// the greeting message if no banner is configured.
+const defaultBanner = "Service ready"
+
+// A VirtualHost is the settings of a name-based virtual FTP host.
+// A client selects a virtual host by HOST command before USER command,
+// or by the server name indication (SNI) of TLS.
+// https://tools.ietf.org/html/rfc7151
+type VirtualHost struct {
+	// FileSystem is the file system of the host.
+	// If it is nil, Server.FileSystem is used.
+	FileSystem vfs.FileSystem
+
+	// Authorizer authorizes the users of the host.
+	// If it is nil, Server.Authorizer is used,
+	// and the users of Server.Authorizer can log in to the host by sending HOST command.
+	Authorizer Authorizer
+
+	// Banner is the greeting message of the host.
+	// It is sent in reply to HOST command, or on connection if the host is selected by SNI.
+	// If it is empty, the banner of the listener or Server.Banner is used.
+	Banner string
+
+	// LoginMessage is the template of the message shown on login.
+	// If it is nil, the login message of the listener or Server.LoginMessage is used.
+	LoginMessage *template.Template
+
+	// DirMessage is the name of the message files shown on changing the directory, e.g. ".message".
+	// If it is empty, the name of the listener or Server.DirMessage is used.
+	DirMessage string
+
+	// Certificate is the certificate for the clients that request the host by SNI.
+	// If it is nil, the certificates of Server.TLSConfig are used.
+	Certificate *tls.Certificate
+}
+
+// hostName normalizes the argument of HOST command and the server name of TLS.
+// The names are case insensitive, and IP addresses may be enclosed in brackets.
+func hostName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		name = name[1 : len(name)-1]
+	}
+	return name
+}
+
+// lookupHost returns the virtual host that has the name.
+// It returns nil if there is no such host.
+func (s *Server) lookupHost(name string) *VirtualHost {
+	if name == "" || len(s.VirtualHosts) == 0 {
+		return nil
+	}
+	return s.VirtualHosts[hostName(name)]
+}
+
+// ResolveHost returns the settings of the virtual host that c selected.
+// The settings that the virtual host doesn't have are filled with the ones of the listener that accepted c,
+// and then with the ones of s.
+// If c selects no virtual host, it returns the settings of s.
+func (s *Server) ResolveHost(c *ServerConn) VirtualHost {
+	h := VirtualHost{
+		FileSystem:   s.FileSystem,
+		Authorizer:   s.authorizer(),
+		Banner:       s.Banner,
+		LoginMessage: s.LoginMessage,
+		DirMessage:   s.DirMessage,
+	}
+
+	// the settings of the listener
+	if banner, ok := c.ctx.Value(BannerContextKey).(string); ok && banner != "" {
+		h.Banner = banner
+	}
+	if tmpl, ok := c.ctx.Value(LoginMessageContextKey).(*template.Template); ok && tmpl != nil {
+		h.LoginMessage = tmpl
+	}
+	if name, ok := c.ctx.Value(DirMessageContextKey).(string); ok && name != "" {
+		h.DirMessage = name
+	}
+
+	if vh := s.lookupHost(c.host); vh != nil {
+		if vh.FileSystem != nil {
+			h.FileSystem = vh.FileSystem
+		}
+		if vh.Authorizer != nil {
+			h.Authorizer = vh.Authorizer
+		}
+		if vh.Banner != "" {
+			h.Banner = vh.Banner
+		}
+		if vh.LoginMessage != nil {
+			h.LoginMessage = vh.LoginMessage
+		}
+		if vh.DirMessage != "" {
+			h.DirMessage = vh.DirMessage
+		}
+		h.Certificate = vh.Certificate
+	}
+	if h.FileSystem == nil {
+		h.FileSystem = vfs.Null
+	}
+	if h.Banner == "" {
+		h.Banner = defaultBanner
+	}
+	return h
+}
+
+// tlsConfigWithHosts returns a copy of config that presents the certificates of the virtual hosts.
+// It returns config itself if no virtual host has its own certificate.
+func (s *Server) tlsConfigWithHosts(config *tls.Config) *tls.Config {
+	found := false
+	for _, h := range s.VirtualHosts {
+		if h.Certificate != nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return config
+	}
+
+	config = config.Clone()
+	getCertificate := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if h := s.lookupHost(hello.ServerName); h != nil && h.Certificate != nil {
+			return h.Certificate, nil
+		}
+		if getCertificate != nil {
+			return getCertificate(hello)
+		}
+		// fall back to config.Certificates.
+		return nil, nil
+	}
+	return config
+}
+
+// Host returns the name of the virtual host that the client selected by HOST command or SNI.
+// It returns an empty string if no virtual host is selected.
+func (c *ServerConn) Host() string {
+	return c.host
+}
+
+// selectHostByTLS selects the virtual host by the server name indication of the TLS session.
+// The host selected by HOST command has priority.
+func (c *ServerConn) selectHostByTLS(conn *tls.Conn) {
+	name := hostName(conn.ConnectionState().ServerName)
+	if c.host != "" || c.server.lookupHost(name) == nil {
+		return
+	}
+	c.server.logger().Printf(c.getSessionID(), "the virtual host %s is selected by SNI", name)
+	c.host = name
+	c.sniHost = name
+}
+
+// banner returns the lines of the greeting message.
+func (c *ServerConn) banner() []string {
+	if lines := messageLines(c.server.ResolveHost(c).Banner); len(lines) > 0 {
+		return lines
+	}
+	return []string{defaultBanner}
+}
@@ -0,0 +1,405 @@
+package ftp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	pkgpath "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shogo82148/s3ftpgateway/vfs"
+	"golang.org/x/text/encoding"
+)
+
+// the default limits of recursive listings.
+const (
+	defaultMaxListDepth   = 16
+	defaultMaxListEntries = 100000
+)
+
+// the maximum size in bytes of the listing that STAT command sends over the control connection.
+const maxStatSize = 64 * 1024
+
+// ListStyle is the format of the long listings, e.g. the output of LIST command.
+type ListStyle int
+
+const (
+	// ListStyleUnix is the format of "ls -l" on Unix systems. It is the default.
+	ListStyleUnix ListStyle = iota
+
+	// ListStyleDOS is the format of the "dir" command on MS-DOS, same as IIS.
+	// Some legacy clients can't parse other formats.
+	ListStyleDOS
+)
+
+// errListTruncated is returned when a listing reaches the limits.
+var errListTruncated = errors.New("ftp: listing truncated")
+
+// listOptions is the options of LIST and NLST commands.
+// RFC 959 says the argument is a path name, but most ftp clients send the options of ls(1),
+// e.g. "LIST -la" or "NLST -R dir".
+type listOptions struct {
+	all       bool // -a: include the names that begin with a dot
+	long      bool // -l: use the long listing format
+	recursive bool // -R: list subdirectories recursively
+	sortBy    byte // -t or -S: sort by the modification time or the size
+	path      string
+}
+
+// parseListOptions parses the argument of LIST and NLST commands.
+// The unknown options are ignored. "--" terminates the options.
+func parseListOptions(arg string) listOptions {
+	var opts listOptions
+	for len(arg) > 1 && arg[0] == '-' {
+		flags, rest, _ := strings.Cut(arg, " ")
+		arg = strings.TrimLeft(rest, " ")
+		if flags == "--" {
+			break
+		}
+		for _, flag := range flags[1:] {
+			switch flag {
+			case 'a':
+				opts.all = true
+			case 'l':
+				opts.long = true
+			case 'R':
+				opts.recursive = true
+			case 't', 'S':
+				opts.sortBy = byte(flag)
+			}
+		}
+	}
+	opts.path = arg
+	return opts
+}
+
+// hasMeta reports whether name contains any of the magic characters recognized by path.Match.
+func hasMeta(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// listEntry is an entry of listings.
+type listEntry struct {
+	os.FileInfo
+
+	// the path of the entry in the file system.
+	path string
+
+	// the name that is shown to the client.
+	display string
+}
+
+// lister writes the listing of LIST and NLST commands.
+type lister struct {
+	c    *ServerConn
+	opts listOptions
+	w    io.Writer
+
+	bytes   int64
+	entries int
+
+	// the maximum size of the listing in bytes. zero means no limit.
+	maxBytes int64
+
+	// the charset of the names. nil means UTF-8.
+	charset encoding.Encoding
+
+	// the logged in user, shown as the owner of the files in the long format.
+	user string
+}
+
+// listTarget returns the entries that the argument of LIST and NLST commands points,
+// and whether the argument is a directory.
+// If the last component of the path contains magic characters, it is matched against the names in the directory.
+func (c *ServerConn) listTarget(ctx context.Context, opts listOptions) ([]listEntry, bool, error) {
+	fs := c.fileSystem()
+	if opts.path == "" {
+		list, err := c.readDir(ctx, c.pwd, "", opts)
+		return list, err == nil, err
+	}
+
+	path := c.buildPath(opts.path)
+	dir, pattern := pkgpath.Split(opts.path)
+	if hasMeta(pattern) {
+		if _, err := pkgpath.Match(pattern, ""); err != nil {
+			return nil, false, err
+		}
+		list, err := c.readDir(ctx, pkgpath.Dir(path), dir, listOptions{all: true})
+		if err != nil {
+			return nil, false, err
+		}
+		ret := []listEntry{}
+		for _, entry := range list {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") {
+				// like shells, a pattern doesn't match the names that begin with a dot.
+				continue
+			}
+			if ok, _ := pkgpath.Match(pattern, name); ok {
+				ret = append(ret, entry)
+			}
+		}
+		if len(ret) == 0 {
+			return nil, false, &os.PathError{Op: "glob", Path: path, Err: os.ErrNotExist}
+		}
+		sortListEntries(ret, opts)
+		return ret, false, nil
+	}
+
+	list, err := c.readDir(ctx, path, "", opts)
+	if err == nil && len(list) > 0 {
+		return list, true, nil
+	}
+
+	// the path may be a file. some file systems don't distinguish
+	// an empty directory from a file, so check it.
+	stat, serr := fs.Lstat(ctx, path)
+	if serr != nil {
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, serr
+	}
+	if stat.IsDir() {
+		return list, true, nil
+	}
+	return []listEntry{{FileInfo: stat, path: path, display: opts.path}}, false, nil
+}
+
+// readDir reads the directory and returns the entries in the order of listings.
+// prefix is prepended to the names shown to the client.
+func (c *ServerConn) readDir(ctx context.Context, path, prefix string, opts listOptions) ([]listEntry, error) {
+	info, err := c.fileSystem().ReadDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]listEntry, 0, len(info))
+	for _, fi := range info {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") && !opts.all {
+			continue
+		}
+		list = append(list, listEntry{
+			FileInfo: fi,
+			path:     pkgpath.Join(path, name),
+			display:  prefix + name,
+		})
+	}
+	sortListEntries(list, opts)
+	return list, nil
+}
+
+func sortListEntries(list []listEntry, opts listOptions) {
+	switch opts.sortBy {
+	case 't':
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].ModTime().After(list[j].ModTime())
+		})
+	case 'S':
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Size() > list[j].Size()
+		})
+	}
+}
+
+// list writes the entries.
+// If the recursive option is set, it also writes the entries in the subdirectories
+// up to Server.MaxListDepth levels and Server.MaxListEntries entries.
+func (l *lister) list(ctx context.Context, list []listEntry) error {
+	if !l.opts.recursive {
+		return l.writeEntries(list)
+	}
+
+	if l.opts.long {
+		// like ls -R, the listing of a directory begins with its name.
+		header := l.opts.path
+		if header == "" || hasMeta(pkgpath.Base(header)) {
+			header = "."
+		}
+		if err := l.printf("%s:\r\n", header); err != nil {
+			return err
+		}
+	}
+	if err := l.writeEntries(list); err != nil {
+		return err
+	}
+	return l.walk(ctx, list, 1)
+}
+
+func (l *lister) walk(ctx context.Context, list []listEntry, depth int) error {
+	if depth > l.c.server.maxListDepth() {
+		return nil
+	}
+	for _, entry := range list {
+		if !entry.IsDir() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sub, err := l.c.readDir(ctx, entry.path, entry.display+"/", l.opts)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			// skip the directory, like ls does.
+			l.c.server.logger().Printf(l.c.getSessionID(), "fail to list directory %s: %v", entry.path, err)
+			continue
+		}
+		if l.opts.long {
+			if err := l.printf("\r\n%s:\r\n", entry.display); err != nil {
+				return err
+			}
+		}
+		if err := l.writeEntries(sub); err != nil {
+			return err
+		}
+		if err := l.walk(ctx, sub, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lister) writeEntries(list []listEntry) error {
+	for _, entry := range list {
+		if l.entries >= l.c.server.maxListEntries() || (l.maxBytes > 0 && l.bytes >= l.maxBytes) {
+			return errListTruncated
+		}
+		l.entries++
+
+		var err error
+		if l.opts.long {
+			err = l.printf("%s\r\n", formatFileInfo(entry.FileInfo, l.c.server.ListStyle, l.c.server.listLocation(), time.Now(), l.user))
+		} else {
+			err = l.printf("%s\r\n", entry.display)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lister) printf(format string, a ...any) error {
+	n, err := io.WriteString(l.w, encodeString(l.charset, fmt.Sprintf(format, a...)))
+	l.bytes += int64(n)
+	return err
+}
+
+// formatFileInfo formats fi in the style, using the time zone loc.
+// user is the owner and the group of the file, if the file system doesn't know them.
+func formatFileInfo(fi os.FileInfo, style ListStyle, loc *time.Location, now time.Time, user string) string {
+	mtime := fi.ModTime().In(loc)
+	if style == ListStyleDOS {
+		if fi.IsDir() {
+			return fmt.Sprintf("%s       <DIR>          %s", mtime.Format("01-02-06  03:04PM"), fi.Name())
+		}
+		return fmt.Sprintf("%s %20d %s", mtime.Format("01-02-06  03:04PM"), fi.Size(), fi.Name())
+	}
+
+	// like ls, show the year instead of the time if the file is older than six months, or in the future.
+	layout := " Jan _2 15:04"
+	if mtime.Before(now.AddDate(0, -6, 0)) || mtime.After(now) {
+		layout = " Jan _2  2006"
+	}
+
+	// a directory has at least two links, its entry in the parent and "." in itself.
+	links := 1
+	if fi.IsDir() {
+		links = 2
+	}
+
+	owner, group := vfs.Owner(fi)
+	if owner == "" {
+		owner = user
+	}
+	if group == "" {
+		group = user
+	}
+
+	return fmt.Sprintf(
+		"%s %d %s %s %13d %s %s",
+		fi.Mode(), links,
+		owner, group,
+		fi.Size(),
+		mtime.Format(layout),
+		fi.Name(),
+	)
+}
+
+// executeList executes LIST and NLST commands.
+// LIST always uses the long listing format.
+func executeList(ctx context.Context, c *ServerConn, cmd *Command, long bool) {
+	opts := parseListOptions(cmd.Arg)
+	opts.long = opts.long || long
+
+	list, _, err := c.listTarget(ctx, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, pkgpath.ErrBadPattern):
+			c.WriteReply(StatusBadArguments, "Invalid pattern.")
+		case os.IsNotExist(err):
+			c.WriteReply(StatusNeedSomeUnavailableResource, "No such file or directory.")
+		case os.IsPermission(err):
+			c.WriteReply(StatusNeedSomeUnavailableResource, "Permission denied.")
+		default:
+			c.WriteReply(StatusBadCommand, "Internal error.")
+		}
+		return
+	}
+	c.WriteReply(StatusAboutToSend, "File status okay; about to open data connection.")
+
+	// tctx is a context for transfering data
+	tctx, cancel := c.newTransferContext()
+	charset := c.charset
+	user := c.auth.User
+	conn, err := c.dt.Conn(tctx)
+	if err != nil {
+		cancel()
+		c.server.logger().Printf(c.getSessionID(), "fail to start data connection: %v", err)
+		c.WriteReply(StatusTransfertAborted, "Requested file action aborted.")
+		return
+	}
+
+	format := c.dataFormat()
+	c.startTransfer(func() {
+		defer c.closeDataTransfer()
+		defer cancel()
+		wire := &countWriter{Writer: conn}
+		dw := format.writer(wire, 0)
+		w := bufio.NewWriter(dw)
+		l := &lister{c: c, opts: opts, w: w, charset: charset, user: user}
+		err := l.list(tctx, list)
+		truncated := errors.Is(err, errListTruncated)
+		if truncated {
+			c.server.logger().Printf(c.getSessionID(), "listing truncated after %d entries", l.entries)
+			err = nil
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = dw.Close()
+		}
+		if err != nil && handleAbort(tctx, c) {
+			return
+		}
+		if err != nil {
+			c.server.logger().Printf(c.getSessionID(), "fail to list directory: %v", err)
+			c.WriteReply(StatusActionAborted, "Requested file action aborted.")
+			return
+		}
+		c.bytesSent.Add(l.bytes)
+		if truncated {
+			c.WriteReplyf(StatusClosingDataConnection, "Listing truncated after %d entries; %s", l.entries, byteCounts(l.bytes, wire.count))
+			return
+		}
+		c.WriteReplyf(StatusClosingDataConnection, "Data transfer starting %s", byteCounts(l.bytes, wire.count))
+	})
+}
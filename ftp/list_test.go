@@ -0,0 +1,170 @@
+package ftp
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseListOptions(t *testing.T) {
+	cases := []struct {
+		arg  string
+		want listOptions
+	}{
+		{
+			arg:  "",
+			want: listOptions{},
+		},
+		{
+			arg:  "foo",
+			want: listOptions{path: "foo"},
+		},
+		{
+			arg:  "-la",
+			want: listOptions{all: true, long: true},
+		},
+		{
+			arg:  "-l -R  foo bar",
+			want: listOptions{long: true, recursive: true, path: "foo bar"},
+		},
+		{
+			arg:  "-tS *.csv",
+			want: listOptions{sortBy: 'S', path: "*.csv"},
+		},
+		{
+			arg:  "-F -1 foo",
+			want: listOptions{path: "foo"},
+		},
+		{
+			arg:  "-a -- -foo",
+			want: listOptions{all: true, path: "-foo"},
+		},
+		{
+			arg:  "-",
+			want: listOptions{path: "-"},
+		},
+	}
+	for _, c := range cases {
+		got := parseListOptions(c.arg)
+		if got != c.want {
+			t.Errorf("%q: want %#v, got %#v", c.arg, c.want, got)
+		}
+	}
+}
+
+type testFileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+	owner string
+	group string
+}
+
+func (fi testFileInfo) Name() string            { return fi.name }
+func (fi testFileInfo) Size() int64             { return fi.size }
+func (fi testFileInfo) Mode() os.FileMode       { return fi.mode }
+func (fi testFileInfo) ModTime() time.Time      { return fi.mtime }
+func (fi testFileInfo) IsDir() bool             { return fi.mode.IsDir() }
+func (fi testFileInfo) Sys() any                { return nil }
+func (fi testFileInfo) Owner() (string, string) { return fi.owner, fi.group }
+
+func TestFormatFileInfo(t *testing.T) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	now := time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		fi    os.FileInfo
+		style ListStyle
+		loc   *time.Location
+		want  string
+	}{
+		{
+			name: "recent file",
+			fi: testFileInfo{
+				name:  "foo.txt",
+				size:  123,
+				mode:  0644,
+				mtime: time.Date(2020, time.June, 30, 12, 34, 0, 0, time.UTC),
+			},
+			loc:  time.UTC,
+			want: "-rw-r--r-- 1 user user           123  Jun 30 12:34 foo.txt",
+		},
+		{
+			name: "old file",
+			fi: testFileInfo{
+				name:  "foo.txt",
+				size:  123,
+				mode:  0644,
+				mtime: time.Date(2019, time.December, 31, 12, 34, 0, 0, time.UTC),
+			},
+			loc:  time.UTC,
+			want: "-rw-r--r-- 1 user user           123  Dec 31  2019 foo.txt",
+		},
+		{
+			name: "future file",
+			fi: testFileInfo{
+				name:  "foo.txt",
+				size:  123,
+				mode:  0644,
+				mtime: time.Date(2020, time.July, 2, 0, 0, 0, 0, time.UTC),
+			},
+			loc:  time.UTC,
+			want: "-rw-r--r-- 1 user user           123  Jul  2  2020 foo.txt",
+		},
+		{
+			name: "time zone",
+			fi: testFileInfo{
+				name:  "foo.txt",
+				size:  123,
+				mode:  0644,
+				mtime: time.Date(2020, time.June, 30, 20, 0, 0, 0, time.UTC),
+			},
+			loc:  jst,
+			want: "-rw-r--r-- 1 user user           123  Jul  1 05:00 foo.txt",
+		},
+		{
+			name: "owner",
+			fi: testFileInfo{
+				name:  "foo",
+				mode:  os.ModeDir | 0755,
+				mtime: time.Date(2020, time.June, 30, 12, 34, 0, 0, time.UTC),
+				owner: "1000",
+				group: "100",
+			},
+			loc:  time.UTC,
+			want: "drwxr-xr-x 2 1000 100             0  Jun 30 12:34 foo",
+		},
+		{
+			name: "dos file",
+			fi: testFileInfo{
+				name:  "foo.txt",
+				size:  123,
+				mode:  0644,
+				mtime: time.Date(2020, time.June, 30, 12, 34, 0, 0, time.UTC),
+			},
+			style: ListStyleDOS,
+			loc:   time.UTC,
+			want:  "06-30-20  12:34PM                  123 foo.txt",
+		},
+		{
+			name: "dos directory",
+			fi: testFileInfo{
+				name:  "foo",
+				mode:  os.ModeDir | 0755,
+				mtime: time.Date(2020, time.June, 30, 1, 2, 0, 0, time.UTC),
+			},
+			style: ListStyleDOS,
+			loc:   time.UTC,
+			want:  "06-30-20  01:02AM       <DIR>          foo",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := formatFileInfo(c.fi, c.style, c.loc, now, "user")
+			if got != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
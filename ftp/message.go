@@ -0,0 +1,177 @@
+package ftp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Catalog maps the IDs of the reply messages to the localized messages.
+//
+// The ID of a message is the English message in the source code, e.g. "Not logged in.".
+// The ID of a message that contains values is its format for fmt.Sprintf, e.g. "Directory changed to %s.",
+// and the localized message must have the same verbs in the same order. Validate checks it.
+// The messages that are not in the catalog are sent in English.
+type Catalog map[string]string
+
+// Validate checks that the localized messages have the same verbs as their IDs in the same order.
+// The messages with different verbs send broken replies, e.g. "%!s(MISSING)".
+func (catalog Catalog) Validate() error {
+	ids := make([]string, 0, len(catalog))
+	for id := range catalog {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		want, got := formatVerbs(id), formatVerbs(catalog[id])
+		if strings.Join(want, "") != strings.Join(got, "") {
+			return fmt.Errorf("ftp: the message %q must have the verbs %q of its ID %q, got %q", catalog[id], want, id, got)
+		}
+	}
+	return nil
+}
+
+// formatVerbs returns the verbs in the format for fmt.Sprintf, e.g. ["%s", "%5d"] for "%s: %5d%%".
+func formatVerbs(format string) []string {
+	var verbs []string
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// skip the flags, the width, the precision and the argument indexes.
+		j := i + 1
+		for j < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[j]) >= 0 {
+			j++
+		}
+		if j >= len(format) {
+			verbs = append(verbs, format[i:])
+			break
+		}
+		if format[j] != '%' {
+			verbs = append(verbs, format[i:j+1])
+		}
+		i = j
+	}
+	return verbs
+}
+
+// the language of the messages in the source code.
+const defaultLanguage = "en"
+
+// builtinCatalogs are the catalogs bundled in the package, keyed by the language tags.
+var builtinCatalogs = map[string]Catalog{
+	"ja": catalogJa,
+}
+
+// languageTag normalizes the language tag of RFC 5646.
+// The tags are case insensitive.
+func languageTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// language returns the default language of the reply messages.
+func (s *Server) language() string {
+	if lang := s.matchLanguage(s.Language); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// languages returns the languages that s supports.
+// English comes first, and the others are sorted.
+func (s *Server) languages() []string {
+	set := map[string]struct{}{}
+	for lang := range builtinCatalogs {
+		set[lang] = struct{}{}
+	}
+	for lang := range s.Messages {
+		set[languageTag(lang)] = struct{}{}
+	}
+	delete(set, defaultLanguage)
+	delete(set, "")
+
+	langs := make([]string, 0, len(set)+1)
+	for lang := range set {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return append([]string{defaultLanguage}, langs...)
+}
+
+// matchLanguage returns the supported language that matches tag.
+// If the language isn't supported, the primary language subtag is tried, e.g. "ja" for "ja-JP".
+// It returns an empty string if no language matches.
+func (s *Server) matchLanguage(tag string) string {
+	tag = languageTag(tag)
+	if tag == "" {
+		return ""
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	var found string
+	for _, lang := range s.languages() {
+		if lang == tag {
+			return lang
+		}
+		if lang == primary {
+			found = lang
+		}
+	}
+	return found
+}
+
+// catalog returns the catalog of lang that the operator configures.
+func (s *Server) catalog(lang string) Catalog {
+	if catalog, ok := s.Messages[lang]; ok {
+		return catalog
+	}
+	for k, catalog := range s.Messages {
+		if languageTag(k) == lang {
+			return catalog
+		}
+	}
+	return nil
+}
+
+// message returns the message of the id in lang.
+// The catalogs of the operator have priority over the built-in catalogs.
+// If lang has no message of the id, the message in English is returned.
+func (s *Server) message(lang, id string) string {
+	if msg, ok := s.catalog(lang)[id]; ok {
+		return msg
+	}
+	if msg, ok := builtinCatalogs[lang][id]; ok {
+		return msg
+	}
+	if lang != defaultLanguage {
+		if msg, ok := s.catalog(defaultLanguage)[id]; ok {
+			return msg
+		}
+	}
+	return id
+}
+
+// message returns the message of the id in the language of the connection.
+func (c *ServerConn) message(id string) string {
+	return c.server.message(c.lang, id)
+}
+
+// localize translates the messages into the language of the connection.
+// The lines beginning with a space are data, e.g. the features of FEAT, and are kept as is.
+func (c *ServerConn) localize(messages []string) []string {
+	ret := make([]string, len(messages))
+	for i, msg := range messages {
+		if strings.HasPrefix(msg, " ") {
+			ret[i] = msg
+			continue
+		}
+		ret[i] = c.message(msg)
+	}
+	return ret
+}
+
+// setLanguage changes the language of the reply messages.
+func (c *ServerConn) setLanguage(lang string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lang = lang
+}
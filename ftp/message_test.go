@@ -0,0 +1,60 @@
+package ftp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatVerbs(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"Not logged in.", nil},
+		{"Directory changed to %s.", []string{"%s"}},
+		{"Restarting at %d. Ending at %d.", []string{"%d", "%d"}},
+		{"%s: %5d%% %-10.2f", []string{"%s", "%5d", "%-10.2f"}},
+		{"%[2]s %[1]d", []string{"%[2]s", "%[1]d"}},
+		{"100%", []string{"%"}},
+	}
+	for _, tt := range tests {
+		got := formatVerbs(tt.format)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want %q, got %q", tt.format, tt.want, got)
+		}
+	}
+}
+
+func TestCatalog_Validate(t *testing.T) {
+	valid := Catalog{
+		"Not logged in.":            "Please log in first.",
+		"Directory changed to %s.":  "Now in %s.",
+		"Listing %d entries; %s":    "%d entries (100%%); %s",
+		"Language change to %s":     "Language: %s",
+		"Restarting at %d. Send %s": "Send %s to restart at %d.",
+	}
+	if err := valid.Validate(); err == nil {
+		t.Error("the reordered verbs are accepted")
+	}
+	delete(valid, "Restarting at %d. Send %s")
+	if err := valid.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	for _, catalog := range []Catalog{
+		{"Directory changed to %s.": "Directory changed."},
+		{"Not logged in.": "Not logged in as %s."},
+		{"Maximum idle time set to %d seconds.": "Maximum idle time set to %s seconds."},
+	} {
+		if err := catalog.Validate(); err == nil {
+			t.Errorf("%v: want error, got nil", catalog)
+		}
+	}
+
+	// the built-in catalogs are valid.
+	for lang, catalog := range builtinCatalogs {
+		if err := catalog.Validate(); err != nil {
+			t.Errorf("%s: %v", lang, err)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package ftp
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// transferMode is the data transfer mode described in RFC 959 Section 3.4.
+type transferMode byte
+
+const (
+	transferModeStream  transferMode = 'S'
+	transferModeBlock   transferMode = 'B'
+	transferModeDeflate transferMode = 'Z' // https://tools.ietf.org/html/draft-preston-ftpext-deflate-04
+)
+
+// the compression level of MODE Z, used if the client doesn't set it by OPTS MODE Z LEVEL.
+const defaultDeflateLevel = 6
+
+// descriptor codes of block headers.
+const (
+	blockEOR     = 128 // End of data block is EOR
+	blockEOF     = 64  // End of data block is EOF
+	blockError   = 32  // Suspected errors in data block
+	blockRestart = 16  // Data block is a restart marker
+)
+
+// the maximum size of data in one block.
+const maxBlockSize = 1<<16 - 1
+
+// the interval in bytes of restart markers that RETR sends in block mode.
+var restartMarkerInterval int64 = 1 << 20
+
+// errMissingEOF is returned when the data connection is closed before the EOF block.
+var errMissingEOF = errors.New("ftp: data connection closed before EOF block")
+
+// blockReader decodes the data in block mode.
+type blockReader struct {
+	r io.Reader
+
+	// the number of bytes remaining in the current data block.
+	remain int
+	// the current block is the last block.
+	eof bool
+}
+
+func newBlockReader(r io.Reader) *blockReader {
+	return &blockReader{r: r}
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for r.remain == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.r.Read(p)
+	r.remain -= n
+	if err == io.EOF {
+		if r.remain > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *blockReader) readHeader() error {
+	var header [3]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.EOF {
+			// a sender must send an EOF block explicitly.
+			return errMissingEOF
+		}
+		return err
+	}
+	descriptor := header[0]
+	count := int(binary.BigEndian.Uint16(header[1:]))
+	if descriptor&blockEOF != 0 {
+		r.eof = true
+	}
+	if descriptor&blockRestart != 0 {
+		// the receiver of a restart marker should reply 110, and the sender can restart from it.
+		// however, an upload to the file system is committed at once,
+		// so there is no partial file to restart from. skip the marker.
+		_, err := io.CopyN(io.Discard, r.r, int64(count))
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.remain = count
+	return nil
+}
+
+// blockWriter encodes the data in block mode.
+// It sends restart markers every restartMarkerInterval bytes.
+// The markers are the offsets in the file, so they can be passed to REST.
+type blockWriter struct {
+	w      io.Writer
+	offset int64 // the offset in the file
+	next   int64 // the offset of the next restart marker
+}
+
+func newBlockWriter(w io.Writer, offset int64) *blockWriter {
+	return &blockWriter{
+		w:      w,
+		offset: offset,
+		next:   offset + restartMarkerInterval,
+	}
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		size := len(p)
+		if size > maxBlockSize {
+			size = maxBlockSize
+		}
+		if remain := w.next - w.offset; int64(size) > remain {
+			size = int(remain)
+		}
+		if err := w.writeBlock(0, p[:size]); err != nil {
+			return n, err
+		}
+		n += size
+		w.offset += int64(size)
+		p = p[size:]
+
+		if w.offset >= w.next {
+			if err := w.writeBlock(blockRestart, []byte(strconv.FormatInt(w.offset, 10))); err != nil {
+				return n, err
+			}
+			w.next = w.offset + restartMarkerInterval
+		}
+	}
+	return n, nil
+}
+
+// Close sends the EOF block. It doesn't close the underlying writer.
+func (w *blockWriter) Close() error {
+	return w.writeBlock(blockEOF, nil)
+}
+
+func (w *blockWriter) writeBlock(descriptor byte, data []byte) error {
+	var header [3]byte
+	header[0] = descriptor
+	binary.BigEndian.PutUint16(header[1:], uint16(len(data)))
+	if _, err := w.w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// deflateReader decodes the data in deflate mode.
+// It reads the zlib header lazily, so creating it doesn't block.
+type deflateReader struct {
+	r  io.Reader
+	zr io.ReadCloser
+}
+
+func (r *deflateReader) Read(p []byte) (int, error) {
+	if r.zr == nil {
+		zr, err := zlib.NewReader(r.r)
+		if err != nil {
+			if err == io.EOF {
+				// a sender must send the zlib stream even if the file is empty.
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.zr = zr
+	}
+	return r.zr.Read(p)
+}
+
+// dataFormat is the representation of the file data on the data connection,
+// selected by TYPE, MODE and OPTS MODE Z commands.
+// It is taken before starting a data transfer in the background,
+// because the commands may change them during the transfer.
+type dataFormat struct {
+	mode         transferMode
+	ascii        bool // the line endings are converted.
+	deflateLevel int
+}
+
+// dataFormat returns the current representation of the file data.
+func (c *ServerConn) dataFormat() dataFormat {
+	return dataFormat{
+		mode:         c.mode,
+		ascii:        c.convertsASCII(),
+		deflateLevel: c.deflateLevel,
+	}
+}
+
+// reader returns the reader of the file data sent over the data connection.
+func (f dataFormat) reader(r io.Reader) io.Reader {
+	switch f.mode {
+	case transferModeBlock:
+		r = newBlockReader(r)
+	case transferModeDeflate:
+		r = &deflateReader{r: r}
+	}
+	if f.ascii {
+		r = newASCIIReader(r)
+	}
+	return r
+}
+
+// writer returns the writer of the file data sent over the data connection.
+// offset is the offset in the file of the first byte.
+// The caller must close the returned writer to finish the transfer,
+// but closing it doesn't close the data connection.
+func (f dataFormat) writer(w io.Writer, offset int64) io.WriteCloser {
+	var wc io.WriteCloser
+	switch f.mode {
+	case transferModeBlock:
+		bw := newBlockWriter(w, offset)
+		if f.ascii {
+			// the offsets of the converted data don't match the file,
+			// so restart markers are useless.
+			bw.next = math.MaxInt64
+		}
+		wc = bw
+	case transferModeDeflate:
+		zw, err := zlib.NewWriterLevel(w, f.deflateLevel)
+		if err != nil {
+			// the level is validated by OPTS MODE Z LEVEL, so it never happens.
+			panic(err)
+		}
+		wc = zw
+	default:
+		wc = nopWriteCloser{w}
+	}
+	if f.ascii {
+		wc = newASCIIWriter(wc)
+	}
+	return wc
+}
+
+// byteCounts formats the size of the file and the size on the data connection.
+func byteCounts(file, wire int64) string {
+	if file == wire {
+		return fmt.Sprintf("%d bytes", file)
+	}
+	return fmt.Sprintf("%d bytes (%d bytes on the wire)", file, wire)
+}
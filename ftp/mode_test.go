@@ -0,0 +1,122 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlockWriter(t *testing.T) {
+	defer func(interval int64) { restartMarkerInterval = interval }(restartMarkerInterval)
+	restartMarkerInterval = 4
+
+	var buf bytes.Buffer
+	w := newBlockWriter(&buf, 2)
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\x00\x00\x04hell" + // data block
+		"\x10\x00\x016" + // restart marker at offset 6
+		"\x00\x00\x01o" + // data block
+		"\x40\x00\x00" // EOF block
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the markers are skipped by the reader.
+	data, err := io.ReadAll(newBlockReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("want %q, got %q", "hello", data)
+	}
+}
+
+func TestBlockReader(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{
+			name:  "eof",
+			input: "\x00\x00\x03foo\x40\x00\x03bar",
+			want:  "foobar",
+		},
+		{
+			name:  "trailing data",
+			input: "\x40\x00\x03foobar",
+			want:  "foo",
+		},
+		{
+			name:  "missing eof",
+			input: "\x00\x00\x03foo",
+			want:  "foo",
+			err:   errMissingEOF,
+		},
+		{
+			name:  "truncated block",
+			input: "\x40\x00\x03fo",
+			want:  "fo",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "truncated header",
+			input: "\x00\x00\x03foo\x40",
+			want:  "foo",
+			err:   io.ErrUnexpectedEOF,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := io.ReadAll(newBlockReader(strings.NewReader(c.input)))
+			if !errors.Is(err, c.err) {
+				t.Errorf("want error %v, got %v", c.err, err)
+			}
+			if string(data) != c.want {
+				t.Errorf("want %q, got %q", c.want, data)
+			}
+		})
+	}
+}
+
+func TestDeflateReader(t *testing.T) {
+	var buf bytes.Buffer
+	f := dataFormat{mode: transferModeDeflate, deflateLevel: defaultDeflateLevel}
+	w := f.writer(&buf, 0)
+	if _, err := io.WriteString(w, "hello hello hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := buf.Bytes()
+
+	data, err := io.ReadAll(f.reader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello hello hello" {
+		t.Errorf("want %q, got %q", "hello hello hello", data)
+	}
+
+	// the data connection is closed before the end of the zlib stream.
+	_, err = io.ReadAll(f.reader(bytes.NewReader(compressed[:len(compressed)-4])))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("want error %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	// empty stream.
+	_, err = io.ReadAll(f.reader(strings.NewReader("")))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("want error %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
@@ -0,0 +1,138 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	pkgpath "path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// BannerContextKey is a context key.
+// It can be used in BaseContext to set the greeting message of the listener.
+// The associated value will be of type string.
+// The banner of the virtual host, VirtualHost.Banner, has priority over it.
+var BannerContextKey = &contextKey{"banner"}
+
+// LoginMessageContextKey is a context key.
+// It can be used in BaseContext to set the login message of the listener.
+// The associated value will be of type *template.Template.
+// The login message of the virtual host, VirtualHost.LoginMessage, has priority over it.
+var LoginMessageContextKey = &contextKey{"login-message"}
+
+// DirMessageContextKey is a context key.
+// It can be used in BaseContext to set the name of the directory message files of the listener.
+// The associated value will be of type string.
+// The name of the virtual host, VirtualHost.DirMessage, has priority over it.
+var DirMessageContextKey = &contextKey{"dir-message"}
+
+// the maximum size in bytes of the directory message files.
+const maxDirMessageSize = 16 * 1024
+
+// Quota is the storage usage of a user.
+type Quota struct {
+	// Used is the size in bytes that the user uses.
+	Used int64
+
+	// Limit is the size in bytes that the user can use. Zero means no limit.
+	Limit int64
+}
+
+// LoginMessageData is the data passed to the templates of the login messages.
+type LoginMessageData struct {
+	// User is the name of the user who logged in.
+	User string
+
+	// SessionID is the identifier of the session.
+	SessionID string
+
+	// ClientIP is the IP address of the client.
+	ClientIP string
+
+	// Listener is the name of the listener that accepted the session.
+	Listener string
+
+	// Host is the name of the virtual host that the client selected.
+	Host string
+
+	// Time is the time when the user logged in.
+	Time time.Time
+
+	// Quota is the storage usage of the user, reported by the Authorizer.
+	// It is nil if the Authorizer doesn't know it, so the templates should check it, e.g. {{with .Quota}}.
+	Quota *Quota
+}
+
+// CheckLoginMessage executes the template of the login message with sample data,
+// to find the errors that occur only on execution, e.g. references to unknown fields.
+// Otherwise the login message is silently dropped on login.
+// The sample data has no Quota, because most Authorizers don't report it.
+func CheckLoginMessage(tmpl *template.Template) error {
+	data := &LoginMessageData{
+		User:      "anonymous",
+		SessionID: "00000000",
+		ClientIP:  "192.0.2.1",
+		Listener:  "ftp",
+		Host:      "ftp.example.com",
+		Time:      time.Now(),
+	}
+	return tmpl.Execute(io.Discard, data)
+}
+
+// messageLines splits the message into the lines of a reply.
+func messageLines(msg string) []string {
+	msg = strings.TrimRight(strings.ReplaceAll(msg, "\r\n", "\n"), "\n")
+	if msg == "" {
+		return nil
+	}
+	return strings.Split(msg, "\n")
+}
+
+// loginMessage returns the lines of the message that are shown on login.
+func (c *ServerConn) loginMessage() []string {
+	tmpl := c.server.ResolveHost(c).LoginMessage
+	if tmpl == nil {
+		return nil
+	}
+
+	var ip string
+	if addr := c.remoteIP(); addr != nil {
+		ip = addr.String()
+	}
+	data := &LoginMessageData{
+		User:      c.auth.User,
+		SessionID: c.getSessionID(),
+		ClientIP:  ip,
+		Listener:  c.ListenerName(),
+		Host:      c.host,
+		Time:      time.Now(),
+		Quota:     c.auth.Quota,
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		c.server.logger().Printf(c.getSessionID(), "fail to execute the login message: %v", err)
+		return nil
+	}
+	return messageLines(buf.String())
+}
+
+// dirMessage returns the lines of the message file in the directory, e.g. ".message".
+// It returns nil if the file doesn't exist.
+func (c *ServerConn) dirMessage(ctx context.Context, dir string) []string {
+	name := c.server.ResolveHost(c).DirMessage
+	if name == "" {
+		return nil
+	}
+	f, err := c.fileSystem().Open(ctx, pkgpath.Join(dir, name))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxDirMessageSize))
+	if err != nil {
+		c.server.logger().Printf(c.getSessionID(), "fail to read the directory message: %v", err)
+		return nil
+	}
+	return messageLines(string(b))
+}
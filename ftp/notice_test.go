@@ -0,0 +1,29 @@
+package ftp
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestCheckLoginMessage(t *testing.T) {
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{"Welcome {{.User}} from {{.ClientIP}}", true},
+		{"{{.Time.Format \"2006-01-02\"}} {{.Listener}} {{.Host}} {{.SessionID}}", true},
+		{"{{with .Quota}}{{.Used}}/{{.Limit}}{{end}}", true},
+		{"{{.Quota.Used}}", false},
+		{"{{.User.Name}}", false},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("login").Parse(tt.msg))
+		err := CheckLoginMessage(tmpl)
+		if tt.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.msg, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%q: want error, got nil", tt.msg)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package ftp
 
 import (
-	"bufio"
 	"context"
 	"crypto/rand"
 	"crypto/tls"
@@ -12,6 +11,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/shogo82148/s3ftpgateway/vfs"
@@ -19,6 +19,19 @@ import (
 
 var defaultDialer net.Dialer
 
+// contextKey is a value for use with context.WithValue. It's used as
+// a pointer so it fits in an interface{} without allocation.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "ftp context value " + k.name }
+
+// ListenerNameContextKey is a context key.
+// It can be used in BaseContext to name the listener.
+// The associated value will be of type string.
+var ListenerNameContextKey = &contextKey{"listener-name"}
+
 type atomicBool int32
 
 func (b *atomicBool) isSet() bool { return atomic.LoadInt32((*int32)(b)) != 0 }
@@ -45,6 +58,34 @@ type Server struct {
 	// tls.Config.SetSessionTicketKeys.
 	TLSConfig *tls.Config
 
+	// Banner is the greeting message sent on connection, e.g. a legal notice.
+	// It may have multiple lines. If it is empty, "Service ready" is used.
+	Banner string
+
+	// LoginMessage is the template of the message shown on login, e.g. the message of the day.
+	// The template is executed with LoginMessageData. If it is nil, no message is shown.
+	LoginMessage *template.Template
+
+	// DirMessage is the name of the message files, e.g. ".message".
+	// If the directory has the file, its content is shown on changing into the directory by CWD and CDUP commands.
+	// If it is empty, no message is shown.
+	DirMessage string
+
+	// Language is the language tag of the reply messages until a client changes it by LANG command, e.g. "ja".
+	// If it is empty, English is used.
+	Language string
+
+	// Messages are the catalogs of the reply messages, keyed by the lower-case language tags.
+	// They override the built-in catalogs, so operators can change the wording of the replies,
+	// e.g. the catalog for "en" replaces the English messages.
+	// The languages that have catalogs are negotiable by LANG command.
+	Messages map[string]Catalog
+
+	// VirtualHosts are the name-based virtual hosts, keyed by the lower-case host names.
+	// A client selects one by HOST command or SNI of TLS.
+	// If the client selects no virtual host, the settings of the Server are used.
+	VirtualHosts map[string]*VirtualHost
+
 	// Logger specifies an optional logger.
 	// If nil, logging is done via the log package's standard logger.
 	Logger Logger
@@ -69,15 +110,73 @@ type Server struct {
 	// because it has some security risk, and most clients use passive mode.
 	EnableActiveMode bool
 
+	// EnableCCC enables CCC command, that shuts down the TLS session of the control connection.
+	// The data connections are still protected, but the following commands are sent in plaintext.
+	// It is disabled by default.
+	EnableCCC bool
+
+	// EnableRestart enables REST and RANG commands, that resume downloads from the middle of files.
+	// Only RETR can be restarted, in binary type (TYPE I) with the stream or block mode,
+	// because uploads are committed at once and the offsets of ASCII type and MODE Z don't match the files.
+	// It is disabled by default.
+	EnableRestart bool
+
 	// DisableAddressCheck disables checking address of data connection peer.
 	// The checking is enabled by default to avoid the bounce attack.
+	// Use FXPRules to permit the specific peers instead.
 	DisableAddressCheck bool
 
+	// FXPRules permit the data connections with the peers other than the client, e.g. other FTP servers.
+	// A data connection is permitted if any rule matches.
+	FXPRules []FXPRule
+
+	// ASCIIPassThrough disables the line ending conversion of TYPE A.
+	// If it is true, TYPE A transfers files unchanged, same as TYPE I.
+	ASCIIPassThrough bool
+
+	// RenameCompareAndSwap makes RNTO fail if the file is changed after RNFR.
+	// It needs the file system that provides entity tags (see vfs.ETag).
+	RenameCompareAndSwap bool
+
+	// IdleTimeout is the maximum amount of time to wait for the next command.
+	// The connection is closed if it is idle and no data transfer is in progress.
+	// If it is zero, there is no timeout.
+	IdleTimeout time.Duration
+
+	// MaxIdleTimeout is the maximum idle timeout that users can set by SITE IDLE.
+	// If it is zero, IdleTimeout is used.
+	MaxIdleTimeout time.Duration
+
+	// ListStyle is the format of the long listings.
+	// The default is ListStyleUnix.
+	ListStyle ListStyle
+
+	// ListLocation is the time zone of the times in the long listings.
+	// If it is nil, the local time zone of the server is used.
+	ListLocation *time.Location
+
+	// MaxListDepth is the maximum depth of subdirectories that LIST -R and NLST -R list.
+	// If it is zero, the default depth 16 is used.
+	MaxListDepth int
+
+	// MaxListEntries is the maximum number of entries in one listing.
+	// The listing is truncated if it has more entries.
+	// If it is zero, the default number 100000 is used.
+	MaxListEntries int
+
+	// BaseContext optionally specifies a function that returns
+	// the base context for incoming connections on this server.
+	// The provided Listener is the specific Listener that's
+	// about to start accepting connections.
+	// If BaseContext is nil, the default is context.Background().
+	BaseContext func(net.Listener) context.Context
+
 	shuttingDown atomicBool
 
 	mu            sync.Mutex
 	listeners     map[*net.Listener]struct{}
 	conns         map[*ServerConn]struct{}
+	siteCommands  map[string]SiteCommand
 	ports         []int // ports are available port numbers.
 	idxPorts      []int // inverted index for ports
 	numEmptyPorts int
@@ -156,10 +255,21 @@ func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
 
 // Serve accepts incoming connections on the Listener l, creating a new service goroutine for each.
 func (s *Server) Serve(l net.Listener) error {
-	return s.serve(l, nil)
+	return s.serve(s.baseContext(l), l, nil)
 }
 
-func (s *Server) serve(l net.Listener, tlsConfig *tls.Config) error {
+func (s *Server) baseContext(l net.Listener) context.Context {
+	if s.BaseContext == nil {
+		return context.Background()
+	}
+	ctx := s.BaseContext(l)
+	if ctx == nil {
+		panic("BaseContext returned a nil context")
+	}
+	return ctx
+}
+
+func (s *Server) serve(baseCtx context.Context, l net.Listener, tlsConfig *tls.Config) error {
 	l = &onceCloseListener{Listener: l}
 	defer l.Close()
 
@@ -193,7 +303,7 @@ func (s *Server) serve(l net.Listener, tlsConfig *tls.Config) error {
 		}
 		tempDelay = 0
 
-		c := s.newConn(rw, tlsConfig)
+		c := s.newConn(baseCtx, rw, tlsConfig)
 		if !s.trackConn(c, true) {
 			c.Close()
 			return ErrServerClosed
@@ -229,7 +339,7 @@ func (s *Server) ServeExplicitTLS(l net.Listener, certFile, keyFile string) erro
 		}
 	}
 
-	return s.serve(l, config)
+	return s.serve(s.baseContext(l), l, s.tlsConfigWithHosts(config))
 }
 
 // ServeTLS accepts incoming connections on the Listener l, creating a
@@ -256,20 +366,23 @@ func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
 		}
 	}
 
+	config = s.tlsConfigWithHosts(config)
 	tlsListener := tls.NewListener(l, config)
-	return s.serve(tlsListener, config)
+	return s.serve(s.baseContext(l), tlsListener, config)
 }
 
-func (s *Server) newConn(rwc net.Conn, tlsConfig *tls.Config) *ServerConn {
-	var sessionID string
+// newSessionID returns a random ID for logging.
+func newSessionID() string {
 	var buf [4]byte
 	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
-		sessionID = "????????"
-	} else {
-		sessionID = hex.EncodeToString(buf[:])
+		return "????????"
 	}
+	return hex.EncodeToString(buf[:])
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
+func (s *Server) newConn(baseCtx context.Context, rwc net.Conn, tlsConfig *tls.Config) *ServerConn {
+	sessionID := newSessionID()
+	ctx, cancel := context.WithCancel(baseCtx)
 	c := &ServerConn{
 		ctx:       ctx,
 		cancel:    cancel,
@@ -278,11 +391,17 @@ func (s *Server) newConn(rwc net.Conn, tlsConfig *tls.Config) *ServerConn {
 		sessionID: sessionID,
 		rwc:       rwc,
 		dt:        emptyDataTransfer{},
+		mode:      transferModeStream,
+
+		deflateLevel:  defaultDeflateLevel,
+		hashAlgorithm: vfs.HashSHA256,
+		idleTimeout:   s.IdleTimeout,
+		lang:          s.language(),
 	}
 
 	// setup control channel
-	c.ctrl = newDumbTelnetConn(c.rwc, c.rwc)
-	c.scanner = bufio.NewScanner(c.ctrl)
+	c.setControlConn(c.rwc)
+	c.updateCharset()
 	return c
 }
 
@@ -324,6 +443,17 @@ func (s *Server) closeListenersLocked() error {
 	return err
 }
 
+// activeConns returns the tracked conns.
+func (s *Server) activeConns() []*ServerConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*ServerConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
 // trackConn adds or removes a ServerConn to the set of tracked conns.
 func (s *Server) trackConn(c *ServerConn, add bool) bool {
 	s.mu.Lock()
@@ -411,6 +541,34 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	}
 }
 
+func (s *Server) maxIdleTimeout() time.Duration {
+	if s.MaxIdleTimeout > 0 {
+		return s.MaxIdleTimeout
+	}
+	return s.IdleTimeout
+}
+
+func (s *Server) listLocation() *time.Location {
+	if s.ListLocation != nil {
+		return s.ListLocation
+	}
+	return time.Local
+}
+
+func (s *Server) maxListDepth() int {
+	if s.MaxListDepth > 0 {
+		return s.MaxListDepth
+	}
+	return defaultMaxListDepth
+}
+
+func (s *Server) maxListEntries() int {
+	if s.MaxListEntries > 0 {
+		return s.MaxListEntries
+	}
+	return defaultMaxListEntries
+}
+
 func (s *Server) logger() Logger {
 	if s.Logger == nil {
 		return StdLogger
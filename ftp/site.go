@@ -0,0 +1,237 @@
+package ftp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// A SiteCommand is a subcommand of the SITE command.
+type SiteCommand interface {
+	// Execute executes the subcommand.
+	// cmd.Name is the upper case name of the subcommand, and cmd.Arg is its parameter.
+	Execute(ctx context.Context, c *ServerConn, cmd *Command)
+}
+
+// The SiteCommandFunc type is an adapter to allow the use of ordinary functions as SiteCommand.
+type SiteCommandFunc func(ctx context.Context, c *ServerConn, cmd *Command)
+
+// Execute calls f(ctx, c, cmd).
+func (f SiteCommandFunc) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	f(ctx, c, cmd)
+}
+
+// the built-in subcommands of the SITE command.
+var siteCommands = map[string]SiteCommand{
+	"CHMOD": siteChmod{},
+	"HELP":  siteHelp{},
+	"IDLE":  siteIdle{},
+	"UTIME": siteUtime{},
+	"WHO":   siteWho{},
+}
+
+// HandleSite registers the subcommand of the SITE command for the given name.
+// It replaces the built-in subcommand of the same name.
+// If cmd is nil, the subcommand is disabled.
+func (s *Server) HandleSite(name string, cmd SiteCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.siteCommands == nil {
+		s.siteCommands = make(map[string]SiteCommand)
+	}
+	s.siteCommands[strings.ToUpper(name)] = cmd
+}
+
+// siteCommand returns the subcommand of the SITE command.
+// It returns nil if the subcommand is not found.
+func (s *Server) siteCommand(name string) SiteCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cmd, ok := s.siteCommands[name]; ok {
+		return cmd
+	}
+	return siteCommands[name]
+}
+
+// siteCommandNames returns the sorted names of the available subcommands.
+func (s *Server) siteCommandNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(siteCommands)+len(s.siteCommands))
+	for name := range siteCommands {
+		if cmd, ok := s.siteCommands[name]; ok && cmd == nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	for name, cmd := range s.siteCommands {
+		if _, ok := siteCommands[name]; ok || cmd == nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// adminSiteCommands are the subcommands of the SITE command that show the other users' sessions.
+// The user can execute them only if Authorization.SiteCommands lists them explicitly.
+var adminSiteCommands = map[string]bool{
+	"WHO": true,
+}
+
+// permitsSite reports whether the user is permitted to execute the subcommand of the SITE command.
+func (auth *Authorization) permitsSite(name string) bool {
+	if auth.SiteCommands == nil {
+		return !adminSiteCommands[strings.ToUpper(name)]
+	}
+	for _, n := range auth.SiteCommands {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// siteChmod changes the permission bits of the file.
+//
+//	SITE CHMOD <mode> <path>
+type siteChmod struct{}
+
+func (siteChmod) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	arg, path, ok := strings.Cut(cmd.Arg, " ")
+	if !ok || path == "" {
+		c.WriteReply(StatusBadArguments, "Syntax: SITE CHMOD <mode> <path>")
+		return
+	}
+	mode, err := strconv.ParseUint(arg, 8, 32)
+	if err != nil || mode > 0777 {
+		c.WriteReply(StatusBadArguments, "Invalid mode.")
+		return
+	}
+	if err := vfs.Chmod(ctx, c.fileSystem(), c.buildPath(path), os.FileMode(mode)); err != nil {
+		handleAttrError(c, err)
+		return
+	}
+	c.WriteReply(StatusCommandOK, "SITE CHMOD command successful.")
+}
+
+// siteUtime changes the modification time of the file.
+// The time is in UTC.
+//
+//	SITE UTIME <YYYYMMDDhhmm[ss]> <path>
+//	SITE UTIME <path> <atime> <mtime> <ctime> UTC
+type siteUtime struct{}
+
+func (siteUtime) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	var value, path string
+	if fields := strings.Fields(cmd.Arg); len(fields) >= 5 && strings.EqualFold(fields[len(fields)-1], "UTC") {
+		value = fields[len(fields)-3]
+		path = strings.Join(fields[:len(fields)-4], " ")
+	} else {
+		value, path, _ = strings.Cut(cmd.Arg, " ")
+	}
+	if path == "" {
+		c.WriteReply(StatusBadArguments, "Syntax: SITE UTIME <YYYYMMDDhhmm[ss]> <path>")
+		return
+	}
+
+	layout := "20060102150405"
+	if len(value) == len("200601021504") {
+		layout = "200601021504"
+	}
+	mtime, err := time.Parse(layout, value)
+	if err != nil {
+		c.WriteReply(StatusBadArguments, "Invalid time.")
+		return
+	}
+	if err := vfs.Chtimes(ctx, c.fileSystem(), c.buildPath(path), mtime, time.Time{}); err != nil {
+		handleAttrError(c, err)
+		return
+	}
+	c.WriteReply(StatusCommandOK, "SITE UTIME command successful.")
+}
+
+// siteHelp shows the subcommands that the user can execute.
+type siteHelp struct{}
+
+func (siteHelp) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	msgs := []string{"The following SITE commands are recognized:"}
+	for _, name := range c.server.siteCommandNames() {
+		if c.auth.permitsSite(name) {
+			msgs = append(msgs, " "+name)
+		}
+	}
+	msgs = append(msgs, "Help OK.")
+	c.WriteReply(StatusHelp, msgs...)
+}
+
+// siteWho shows the active sessions.
+type siteWho struct{}
+
+func (siteWho) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	conns := c.server.activeConns()
+
+	now := time.Now()
+	sessions := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		id, user, lastActive := conn.activity()
+		if user == "" {
+			user = "-"
+		}
+		var ip string
+		if addr := conn.remoteIP(); addr != nil {
+			ip = addr.String()
+		}
+		idle := now.Sub(lastActive).Truncate(time.Second)
+		sessions = append(sessions, fmt.Sprintf(" %s %s %s idle %s", id, user, ip, idle))
+	}
+	sort.Strings(sessions)
+
+	msgs := make([]string, 0, len(conns)+2)
+	msgs = append(msgs, fmt.Sprintf(c.message("%d active sessions:"), len(conns)))
+	msgs = append(msgs, sessions...)
+	msgs = append(msgs, "End of list.")
+	c.WriteReply(StatusSystem, msgs...)
+}
+
+// siteIdle shows or changes the idle timeout of the session.
+//
+//	SITE IDLE [<seconds>]
+type siteIdle struct{}
+
+func (siteIdle) Execute(ctx context.Context, c *ServerConn, cmd *Command) {
+	max := c.server.maxIdleTimeout()
+	if cmd.Arg == "" {
+		timeout := c.getIdleTimeout()
+		msg := c.message("No idle time limit.")
+		if timeout > 0 && max > 0 {
+			msg = fmt.Sprintf(c.message("Current idle time limit is %d seconds; max %d seconds."), timeout/time.Second, max/time.Second)
+		} else if timeout > 0 {
+			msg = fmt.Sprintf(c.message("Current idle time limit is %d seconds."), timeout/time.Second)
+		} else if max > 0 {
+			msg = fmt.Sprintf(c.message("No idle time limit; max %d seconds."), max/time.Second)
+		}
+		c.WriteReply(StatusCommandOK, msg)
+		return
+	}
+
+	sec, err := strconv.ParseInt(cmd.Arg, 10, 32)
+	timeout := time.Duration(sec) * time.Second
+	if err != nil || sec <= 0 || (max > 0 && timeout > max) {
+		if max > 0 {
+			c.WriteReplyf(StatusBadArguments, "The idle time limit must be between 1 and %d seconds.", max/time.Second)
+		} else {
+			c.WriteReply(StatusBadArguments, "Invalid idle time limit.")
+		}
+		return
+	}
+	c.setIdleTimeout(timeout)
+	c.WriteReplyf(StatusCommandOK, "Maximum idle time set to %d seconds.", sec)
+}
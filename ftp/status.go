@@ -71,7 +71,17 @@ const (
 	StatusConfidentialReply                  = 633 // Confidentiality protected reply.
 )
 
+// Extra FTP Status codes defined in RFC 1639 https://tools.ietf.org/html/rfc1639
+const (
+	StatusLongPassiveMode = 228 // Entering Long Passive Mode
+)
+
 // Extra FTP Status codes defined in RFC 2428 https://tools.ietf.org/html/rfc2428
 const (
 	StatusNetworkProtoNotSupported = 522 // Network protocol not supported
 )
+
+// Extra FTP Status codes defined in RFC 3659 https://tools.ietf.org/html/rfc3659
+const (
+	StatusInvalidRestParameter = 554 // Requested action not taken: invalid REST parameter.
+)
@@ -9,6 +9,8 @@ import (
 
 const (
 	telnetCmdSe   = 240
+	telnetCmdDm   = 242
+	telnetCmdIp   = 244
 	telnetCmdSb   = 250
 	telnetCmdWill = 251
 	telnetCmdWont = 252
@@ -29,6 +31,13 @@ type dumbTelnetConn struct {
 	mu      sync.Mutex
 	willopt []byte
 	doopt   []byte
+
+	// interrupt is called when the client sends "Interrupt Process".
+	interrupt func()
+
+	// the previous command was "Interrupt Process".
+	// it is used for detecting the Synch signal.
+	interrupted bool
 }
 
 func newDumbTelnetConn(r io.Reader, w io.Writer) *dumbTelnetConn {
@@ -51,6 +60,15 @@ func (c *dumbTelnetConn) Read(buf []byte) (int, error) {
 			return i, io.EOF
 		}
 		if b != telnetCmdIac {
+			if c.interrupted && b == telnetCmdDm {
+				// The Synch signal is "IAC DM" sent in TCP urgent mode, and usually follows "IAC IP".
+				// The IAC is lost from the stream because it is the urgent data,
+				// so the bare DM just after IP is a part of the Synch.
+				// https://tools.ietf.org/html/rfc959#page-34
+				c.interrupted = false
+				continue
+			}
+			c.interrupted = false
 			buf[i] = b
 			i++
 			if b == '\n' {
@@ -64,6 +82,7 @@ func (c *dumbTelnetConn) Read(buf []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		c.interrupted = false
 		switch code {
 		case telnetCmdSb:
 			// One step of subnegotiation, used by either party.
@@ -86,6 +105,11 @@ func (c *dumbTelnetConn) Read(buf []byte) (int, error) {
 			if err != nil {
 				return i, err
 			}
+		case telnetCmdIp:
+			c.interrupted = true
+			if c.interrupt != nil {
+				c.interrupt()
+			}
 		case telnetCmdIac:
 			buf[i] = 255
 			i++
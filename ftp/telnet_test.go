@@ -48,6 +48,19 @@ func TestDumbTelnetConn(t *testing.T) {
 			wout: []byte{0x00},
 		},
 
+		// Interrupt Process and Synch
+		{
+			rin: []byte{
+				0xFF, 0xF4, // Interrupt Process
+				0xF2, // Data Mark, the IAC is sent as urgent data
+				0x41,
+				0xF2, // not a part of Synch
+			},
+			rout: []byte{0x41, 0xF2},
+			win:  []byte{0x00},
+			wout: []byte{0x00},
+		},
+
 		// Telnet Options
 		{
 			rin: []byte{
@@ -90,3 +103,19 @@ func TestDumbTelnetConn(t *testing.T) {
 		}
 	}
 }
+
+func TestDumbTelnetConn_Interrupt(t *testing.T) {
+	var rout, wout bytes.Buffer
+	rw := newDumbTelnetConn(bytes.NewReader([]byte{0xFF, 0xF4, 0xFF, 0xF2, 0x41}), &wout)
+	interrupted := 0
+	rw.interrupt = func() { interrupted++ }
+	if _, err := rout.ReadFrom(rw); err != nil {
+		t.Fatal(err)
+	}
+	if interrupted != 1 {
+		t.Errorf("want interrupted once, got %d", interrupted)
+	}
+	if !reflect.DeepEqual(rout.Bytes(), []byte{0x41}) {
+		t.Errorf("want %v, got %v", []byte{0x41}, rout.Bytes())
+	}
+}
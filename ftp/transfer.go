@@ -42,11 +42,8 @@ func (c *ServerConn) newActiveDataTransfer(ctx context.Context, addr string) (*a
 		return nil, errors.New("invalid address")
 	}
 
-	if !c.server.DisableAddressCheck {
-		ctrl := c.remoteIP()
-		if ctrl == nil || !ip.Equal(ctrl) {
-			return nil, errors.New("invalid address")
-		}
+	if !c.permitsDataPeer(c.authorizedUser(), ip, FXPActive) {
+		return nil, errors.New("invalid address")
 	}
 
 	dialer := c.server.dialer()
@@ -99,6 +96,7 @@ type passiveDataTransfer struct {
 	closed chan struct{}
 	s      *Server
 	c      *ServerConn
+	user   string // the user who requested the transfer
 
 	mu   sync.Mutex
 	conn net.Conn
@@ -135,6 +133,7 @@ func (c *ServerConn) newPassiveDataTransfer() (*passiveDataTransfer, error) {
 		closed: make(chan struct{}),
 		s:      c.server,
 		c:      c,
+		user:   c.authorizedUser(),
 	}
 	go t.listen(ch)
 
@@ -172,6 +171,7 @@ func (t *passiveDataTransfer) listen(ch chan<- chConn) {
 		tempDelay = 0
 
 		if !t.validRemote(rw) {
+			rw.Close()
 			continue
 		}
 
@@ -186,18 +186,11 @@ func (t *passiveDataTransfer) listen(ch chan<- chConn) {
 }
 
 func (t *passiveDataTransfer) validRemote(conn net.Conn) bool {
-	if t.s.DisableAddressCheck {
-		return true
-	}
-	ctrl := t.c.remoteIP()
-	if ctrl == nil {
-		return false
-	}
 	data, ok := conn.RemoteAddr().(*net.TCPAddr)
 	if !ok {
 		return false
 	}
-	return data.IP.Equal(ctrl)
+	return t.c.permitsDataPeer(t.user, data.IP, FXPPassive)
 }
 
 func (t *passiveDataTransfer) Conn(ctx context.Context) (net.Conn, error) {
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/sirupsen/logrus"
@@ -10,10 +11,12 @@ import (
 
 var config string
 var showVersion bool
+var auditNormalization bool
 
 func init() {
 	flag.StringVar(&config, "config", "", "the path to the configure file")
 	flag.BoolVar(&showVersion, "version", false, "show the version")
+	flag.BoolVar(&auditNormalization, "audit-normalization", false, "report the keys whose names collide in the Unicode normalization form, and exit")
 }
 
 func main() {
@@ -33,5 +36,12 @@ func main() {
 	if err != nil {
 		logrus.WithError(err).Fatal("fail to load config")
 	}
+	if auditNormalization {
+		if n := AuditNormalization(c, os.Stdout); n > 0 {
+			logrus.WithField("count", n).Error("found colliding keys")
+			os.Exit(1)
+		}
+		return
+	}
 	Serve(c)
 }
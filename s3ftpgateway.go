@@ -3,20 +3,30 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/shogo82148/s3ftpgateway/ftp"
+	"github.com/shogo82148/s3ftpgateway/vfs"
 	"github.com/shogo82148/s3ftpgateway/vfs/s3fs"
 	"github.com/shogo82148/server-starter/listener"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Serve serves s3ftpgateway service.
@@ -56,10 +66,23 @@ func Serve(config *Config) {
 		logrus.WithError(err).Fatal("fail to get AWS config")
 	}
 
-	fs := &s3fs.FileSystem{
-		Config: cfg,
-		Bucket: config.Bucket,
-		Prefix: config.Prefix,
+	switch types.ChecksumAlgorithm(config.Upload.ChecksumAlgorithm) {
+	case "", types.ChecksumAlgorithmCrc32c, types.ChecksumAlgorithmSha256:
+	default:
+		logrus.Fatalf("unknown checksum algorithm: %s", config.Upload.ChecksumAlgorithm)
+	}
+	rules, err := uploadRules(config.Upload.Rules)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to parse upload rules")
+	}
+	fs := newFileSystem(cfg, config, rules, config.Bucket, config.Prefix)
+	form, normalize, err := normalizationForm(config.Normalization)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to parse s3ftpgateway config")
+	}
+	var fsys vfs.FileSystem = fs
+	if normalize {
+		fsys = vfs.Normalize(fs, form)
 	}
 
 	auth, err := NewAuthorizer(config.Authorizer)
@@ -67,23 +90,84 @@ func Serve(config *Config) {
 		logrus.WithError(err).Fatal("fail to parse s3ftpgateway config")
 	}
 
+	hosts, hostFileSystems, err := virtualHosts(cfg, config, rules)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to configure virtual hosts")
+	}
+	if config.Upload.AbortIncompleteAfter > 0 {
+		for _, target := range sweepTargets(append([]*s3fs.FileSystem{fs}, hostFileSystems...)) {
+			go abortIncompleteUploads(target, config.Upload)
+		}
+	}
+
+	var listStyle ftp.ListStyle
+	switch config.ListStyle {
+	case "", "unix":
+		listStyle = ftp.ListStyleUnix
+	case "dos":
+		listStyle = ftp.ListStyleDOS
+	default:
+		logrus.Fatalf("unknown list style: %s", config.ListStyle)
+	}
+	var listLocation *time.Location
+	if config.ListTimeZone != "" {
+		listLocation, err = time.LoadLocation(config.ListTimeZone)
+		if err != nil {
+			logrus.WithError(err).Fatal("fail to load time zone")
+		}
+	}
+
+	fxpRules, err := parseFXPRules(config.FXP)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to parse FXP rules")
+	}
+
+	loginMessage, err := parseLoginMessage(config.LoginMessage)
+	if err != nil {
+		logrus.WithError(err).Fatal("fail to parse the login message")
+	}
+
+	for lang, catalog := range config.Messages {
+		if err := catalog.Validate(); err != nil {
+			logrus.WithError(err).Fatalf("invalid messages in %s", lang)
+		}
+	}
+
 	cert, err := loadCertificate(config)
 	if err != nil {
 		logrus.WithError(err).Fatal("fail to load certificate")
 	}
 
 	s := &ftp.Server{
-		FileSystem: fs,
+		FileSystem: fsys,
 		Authorizer: auth,
 		TLSConfig: &tls.Config{
 			Certificates: []tls.Certificate{cert},
 		},
-		MinPassivePort:      config.MinPassivePort,
-		MaxPassivePort:      config.MaxPassivePort,
-		PublicIPs:           config.PublicIPs,
-		EnableActiveMode:    config.EnableActiveMode,
-		DisableAddressCheck: !config.EnableAddressCheck,
-		Logger:              logger{},
+		Banner:               config.Banner,
+		LoginMessage:         loginMessage,
+		DirMessage:           config.DirMessage,
+		Language:             config.Language,
+		Messages:             config.Messages,
+		VirtualHosts:         hosts,
+		MinPassivePort:       config.MinPassivePort,
+		MaxPassivePort:       config.MaxPassivePort,
+		PublicIPs:            config.PublicIPs,
+		EnableActiveMode:     config.EnableActiveMode,
+		EnableCCC:            config.EnableCCC,
+		EnableRestart:        config.EnableRestart,
+		DisableAddressCheck:  !config.EnableAddressCheck,
+		FXPRules:             fxpRules,
+		IdleTimeout:          config.IdleTimeout,
+		MaxIdleTimeout:       config.MaxIdleTimeout,
+		ListStyle:            listStyle,
+		ListLocation:         listLocation,
+		MaxListDepth:         config.MaxListDepth,
+		MaxListEntries:       config.MaxListEntries,
+		ASCIIPassThrough:     config.ASCIIPassThrough,
+		RenameCompareAndSwap: config.RenameCompareAndSwap,
+		Logger:               logger{},
+		BaseContext:          listenerContexts(ls),
 	}
 
 	// start to serve
@@ -146,6 +230,289 @@ func Serve(config *Config) {
 	}
 }
 
+// newFileSystem returns the file system on the bucket.
+func newFileSystem(cfg aws.Config, config *Config, rules []s3fs.UploadRule, bucket, prefix string) *s3fs.FileSystem {
+	return &s3fs.FileSystem{
+		Config:        cfg,
+		Bucket:        bucket,
+		Prefix:        prefix,
+		PartSize:      config.Upload.PartSize,
+		Concurrency:   config.Upload.Concurrency,
+		BufferSize:    config.Upload.BufferSize,
+		MaxUploadSize: config.Upload.MaxSize,
+
+		ChecksumAlgorithm: types.ChecksumAlgorithm(config.Upload.ChecksumAlgorithm),
+		UploadRules:       rules,
+		ListMetadata:      config.ListMetadata,
+
+		DownloadConcurrency: config.Download.Concurrency,
+		DownloadPartSize:    config.Download.PartSize,
+		DownloadThreshold:   config.Download.Threshold,
+	}
+}
+
+// virtualHosts returns the virtual hosts in the config, and their file systems.
+func virtualHosts(cfg aws.Config, config *Config, rules []s3fs.UploadRule) (map[string]*ftp.VirtualHost, []*s3fs.FileSystem, error) {
+	if len(config.Hosts) == 0 {
+		return nil, nil, nil
+	}
+	hosts := make(map[string]*ftp.VirtualHost, len(config.Hosts))
+	fileSystems := make([]*s3fs.FileSystem, 0, len(config.Hosts))
+	for _, h := range config.Hosts {
+		name := strings.ToLower(h.Name)
+		if name == "" {
+			return nil, nil, errors.New("the name of the virtual host is empty")
+		}
+		if _, ok := hosts[name]; ok {
+			return nil, nil, fmt.Errorf("duplicated virtual host: %s", name)
+		}
+		// the users of the global authorizer must not reach the other hosts by HOST command.
+		if h.Authorizer.Method == "" {
+			return nil, nil, fmt.Errorf("virtual host %s: the authorizer is required", name)
+		}
+
+		bucket := h.Bucket
+		if bucket == "" {
+			bucket = config.Bucket
+		}
+		fs := newFileSystem(cfg, config, rules, bucket, h.Prefix)
+		fileSystems = append(fileSystems, fs)
+		loginMessage, err := parseLoginMessage(h.LoginMessage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("virtual host %s: %w", name, err)
+		}
+		host := &ftp.VirtualHost{
+			FileSystem:   fs,
+			Banner:       h.Banner,
+			LoginMessage: loginMessage,
+			DirMessage:   h.DirMessage,
+		}
+		if form, ok, err := normalizationForm(config.Normalization); err != nil {
+			return nil, nil, err
+		} else if ok {
+			host.FileSystem = vfs.Normalize(fs, form)
+		}
+
+		auth, err := NewAuthorizer(h.Authorizer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("virtual host %s: %w", name, err)
+		}
+		host.Authorizer = auth
+
+		if h.Certificate != "" {
+			cert, err := tls.LoadX509KeyPair(h.Certificate, h.CertificateKey)
+			if err != nil {
+				return nil, nil, fmt.Errorf("virtual host %s: %w", name, err)
+			}
+			host.Certificate = &cert
+		}
+		hosts[name] = host
+	}
+	return hosts, fileSystems, nil
+}
+
+// parseFXPRules parses the rules for server-to-server transfers.
+func parseFXPRules(configs []FXPRuleConfig) ([]ftp.FXPRule, error) {
+	rules := make([]ftp.FXPRule, 0, len(configs))
+	for _, c := range configs {
+		var mode ftp.FXPMode
+		switch strings.ToLower(c.Mode) {
+		case "":
+			mode = ftp.FXPAny
+		case "active":
+			mode = ftp.FXPActive
+		case "passive":
+			mode = ftp.FXPPassive
+		default:
+			return nil, fmt.Errorf("unknown FXP mode: %s", c.Mode)
+		}
+
+		peers := make([]*net.IPNet, 0, len(c.Peers))
+		for _, peer := range c.Peers {
+			if ip := net.ParseIP(peer); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 8*net.IPv4len
+				}
+				peers = append(peers, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipnet, err := net.ParseCIDR(peer)
+			if err != nil {
+				return nil, err
+			}
+			peers = append(peers, ipnet)
+		}
+
+		rules = append(rules, ftp.FXPRule{
+			User:  c.User,
+			Peers: peers,
+			Mode:  mode,
+		})
+	}
+	return rules, nil
+}
+
+// parseLoginMessage parses the template of the login message, and checks that it can be executed.
+// It returns nil if the message is empty.
+func parseLoginMessage(msg string) (*template.Template, error) {
+	if msg == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("login_message").Parse(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ftp.CheckLoginMessage(tmpl); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// normalizationForm returns the Unicode normalization form of the names.
+// ok is false if the names are not normalized.
+func normalizationForm(name string) (form norm.Form, ok bool, err error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, false, nil
+	case "nfc":
+		return norm.NFC, true, nil
+	case "nfd":
+		return norm.NFD, true, nil
+	}
+	return 0, false, fmt.Errorf("unknown normalization form: %s", name)
+}
+
+// sweepTargets returns the file systems to search for incomplete multipart uploads.
+// A file system under the prefix of another one on the same bucket is skipped,
+// because the uploads are searched by the other one.
+func sweepTargets(fileSystems []*s3fs.FileSystem) []*s3fs.FileSystem {
+	keyPrefix := func(fs *s3fs.FileSystem) string {
+		prefix := strings.Trim(path.Clean("/"+fs.Prefix), "/")
+		if prefix == "" {
+			return ""
+		}
+		return prefix + "/"
+	}
+
+	targets := make([]*s3fs.FileSystem, 0, len(fileSystems))
+	for i, fs := range fileSystems {
+		covered := false
+		for j, other := range fileSystems {
+			if i == j || other.Bucket != fs.Bucket || !strings.HasPrefix(keyPrefix(fs), keyPrefix(other)) {
+				continue
+			}
+			// the same prefix is searched by the first one.
+			if keyPrefix(fs) != keyPrefix(other) || j < i {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			targets = append(targets, fs)
+		}
+	}
+	return targets
+}
+
+// abortIncompleteUploads aborts orphaned multipart uploads periodically.
+func abortIncompleteUploads(fs *s3fs.FileSystem, config UploadConfig) {
+	interval := config.AbortIncompleteInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		n, err := fs.AbortIncompleteUploads(ctx, config.AbortIncompleteAfter)
+		cancel()
+		if err != nil {
+			logrus.WithError(err).Error("fail to abort incomplete uploads")
+		} else if n > 0 {
+			logrus.WithField("count", n).Info("aborted incomplete uploads")
+		}
+		<-ticker.C
+	}
+}
+
+// uploadRules parses the templates of the upload rules.
+func uploadRules(configs []UploadRuleConfig) ([]s3fs.UploadRule, error) {
+	parse := func(templates map[string]string) (map[string]*template.Template, error) {
+		if len(templates) == 0 {
+			return nil, nil
+		}
+		ret := make(map[string]*template.Template, len(templates))
+		for k, v := range templates {
+			tmpl, err := template.New(k).Parse(v)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = tmpl
+		}
+		return ret, nil
+	}
+
+	rules := make([]s3fs.UploadRule, 0, len(configs))
+	for _, c := range configs {
+		tags, err := parse(c.Tags)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := parse(c.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, s3fs.UploadRule{
+			User:        c.User,
+			PathPrefix:  c.PathPrefix,
+			Tags:        tags,
+			Metadata:    metadata,
+			NoOverwrite: c.NoOverwrite,
+		})
+	}
+	return rules, nil
+}
+
+// listenerContexts returns a BaseContext function that names the listeners and sets their settings.
+func listenerContexts(ls []listenerConfig) func(net.Listener) context.Context {
+	configs := make(map[net.Listener]listenerConfig, len(ls))
+	for _, l := range ls {
+		configs[l.listener] = l
+	}
+	return func(l net.Listener) context.Context {
+		config := configs[l]
+		ctx := context.WithValue(context.Background(), ftp.ListenerNameContextKey, config.name)
+		if config.charset != nil {
+			ctx = context.WithValue(ctx, ftp.CharsetContextKey, config.charset)
+		}
+		if config.banner != "" {
+			ctx = context.WithValue(ctx, ftp.BannerContextKey, config.banner)
+		}
+		if config.loginMessage != nil {
+			ctx = context.WithValue(ctx, ftp.LoginMessageContextKey, config.loginMessage)
+		}
+		if config.dirMessage != "" {
+			ctx = context.WithValue(ctx, ftp.DirMessageContextKey, config.dirMessage)
+		}
+		return ctx
+	}
+}
+
+// lookupCharset returns the charset of path names.
+// It returns nil for UTF-8.
+func lookupCharset(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "_")) {
+	case "", "utf_8", "utf8":
+		return nil, nil
+	case "shift_jis", "sjis", "cp932", "windows_31j":
+		return japanese.ShiftJIS, nil
+	case "euc_jp", "eucjp":
+		return japanese.EUCJP, nil
+	}
+	return nil, fmt.Errorf("unknown charset: %s", name)
+}
+
 func serve(s *ftp.Server, l listenerConfig) error {
 	if l.tls {
 		logrus.WithFields(logrus.Fields{
@@ -162,8 +529,13 @@ func serve(s *ftp.Server, l listenerConfig) error {
 }
 
 type listenerConfig struct {
-	listener net.Listener
-	tls      bool
+	listener     net.Listener
+	tls          bool
+	name         string
+	charset      encoding.Encoding
+	banner       string
+	loginMessage *template.Template
+	dirMessage   string
 }
 
 func listeners(config *Config) ([]listenerConfig, error) {
@@ -183,6 +555,16 @@ func listeners(config *Config) ([]listenerConfig, error) {
 				addr = ":ftp"
 			}
 		}
+		charset, err := lookupCharset(listener.Charset)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		loginMessage, err := parseLoginMessage(listener.LoginMessage)
+		if err != nil {
+			lastErr = err
+			continue
+		}
 		l, err := lc.Listen(context.Background(), "tcp", addr)
 		if err != nil {
 			lastErr = err
@@ -190,9 +572,18 @@ func listeners(config *Config) ([]listenerConfig, error) {
 		}
 		l = newTCPKeepAliveListener(l)
 
+		name := listener.Name
+		if name == "" {
+			name = addr
+		}
 		ls = append(ls, listenerConfig{
-			listener: l,
-			tls:      listener.TLS,
+			listener:     l,
+			tls:          listener.TLS,
+			name:         name,
+			charset:      charset,
+			banner:       listener.Banner,
+			loginMessage: loginMessage,
+			dirMessage:   listener.DirMessage,
 		})
 	}
 
@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// ChmodFileSystem is the interface implemented by a file system
+// that can change the mode of files.
+type ChmodFileSystem interface {
+	FileSystem
+
+	// Chmod changes the mode of the named file to mode.
+	Chmod(ctx context.Context, name string, mode os.FileMode) error
+}
+
+// Chmod changes the mode of the named file to mode.
+// If fs doesn't implement ChmodFileSystem, Chmod returns an error wrapping errors.ErrUnsupported.
+func Chmod(ctx context.Context, fs FileSystem, name string, mode os.FileMode) error {
+	if fs, ok := fs.(ChmodFileSystem); ok {
+		return fs.Chmod(ctx, name, mode)
+	}
+	return &os.PathError{
+		Op:   "chmod",
+		Path: name,
+		Err:  errors.ErrUnsupported,
+	}
+}
+
+// ChtimesFileSystem is the interface implemented by a file system
+// that can change the timestamps of files.
+type ChtimesFileSystem interface {
+	FileSystem
+
+	// Chtimes changes the modification time and the creation time of the named file.
+	// If the zero time.Time value is passed for either, that time is left unchanged.
+	Chtimes(ctx context.Context, name string, modTime, createTime time.Time) error
+}
+
+// Chtimes changes the modification time and the creation time of the named file.
+// If the zero time.Time value is passed for either, that time is left unchanged.
+// If fs doesn't implement ChtimesFileSystem, Chtimes returns an error wrapping errors.ErrUnsupported.
+func Chtimes(ctx context.Context, fs FileSystem, name string, modTime, createTime time.Time) error {
+	if fs, ok := fs.(ChtimesFileSystem); ok {
+		return fs.Chtimes(ctx, name, modTime, createTime)
+	}
+	return &os.PathError{
+		Op:   "chtimes",
+		Path: name,
+		Err:  errors.ErrUnsupported,
+	}
+}
+
+// CreateTime returns the creation time of the file.
+// It returns the zero time.Time value if the file system doesn't know it.
+func CreateTime(fi os.FileInfo) time.Time {
+	if fi, ok := fi.(interface{ CreateTime() time.Time }); ok {
+		return fi.CreateTime()
+	}
+	return time.Time{}
+}
+
+// Owner returns the owner and the group of the file.
+// It returns empty strings if the file system doesn't know them.
+func Owner(fi os.FileInfo) (owner, group string) {
+	if fi, ok := fi.(interface{ Owner() (string, string) }); ok {
+		return fi.Owner()
+	}
+	return "", ""
+}
+
+// ContentType returns the media type of the file, e.g. "text/plain".
+// It returns an empty string if the file system doesn't know it.
+func ContentType(fi os.FileInfo) string {
+	if fi, ok := fi.(interface{ ContentType() string }); ok {
+		return fi.ContentType()
+	}
+	return ""
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx that asks Stat and ReadDir to return the attributes set by Chmod and Chtimes,
+// e.g. for MDTM and the facts of MLST and MLSD.
+// The file systems that get them at extra cost, such as s3fs, may get them only if asked.
+func WithMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, metadataKey{}, true)
+}
+
+// MetadataFromContext reports whether ctx asks Stat and ReadDir to return the attributes set by Chmod and Chtimes.
+func MetadataFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(metadataKey{}).(bool)
+	return v
+}
@@ -0,0 +1,101 @@
+package vfs
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Names of hash algorithms.
+// They follow the names in https://tools.ietf.org/html/draft-bryan-ftpext-hash-02
+const (
+	HashCRC32  = "CRC32"
+	HashCRC32C = "CRC32C"
+	HashMD5    = "MD5"
+	HashSHA1   = "SHA-1"
+	HashSHA256 = "SHA-256"
+	HashSHA512 = "SHA-512"
+)
+
+// HashFileSystem is the interface implemented by a file system
+// that stores the checksums of files.
+type HashFileSystem interface {
+	FileSystem
+
+	// Hash returns the checksum of the named file, encoded in lower-case hex.
+	// If the file system doesn't have the checksum in the algorithm,
+	// Hash returns an error that wraps errors.ErrUnsupported.
+	Hash(ctx context.Context, name, algorithm string) (string, error)
+}
+
+// Hash returns the checksum of the named file, encoded in lower-case hex.
+// If fs implements HashFileSystem, Hash calls fs.Hash.
+// Otherwise, or if fs.Hash doesn't have the checksum,
+// Hash reads the file and calculates the checksum.
+func Hash(ctx context.Context, fs FileSystem, name, algorithm string) (string, error) {
+	if fs, ok := fs.(HashFileSystem); ok {
+		sum, err := fs.Hash(ctx, name, algorithm)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return sum, err
+		}
+	}
+
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	r, err := fs.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashRange returns the checksum of length bytes from offset of the named file, encoded in lower-case hex.
+// The checksums stored in the file system are for the whole file, so HashRange always reads the file.
+func HashRange(ctx context.Context, fs FileSystem, name, algorithm string, offset, length int64) (string, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	r, err := OpenOffset(ctx, fs, name, offset)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, io.LimitReader(r, length)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewHash returns a new hash.Hash computing the checksum in the algorithm.
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	case HashCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("vfs: unknown hash algorithm %q: %w", algorithm, errors.ErrUnsupported)
+}
@@ -63,6 +63,11 @@ func dirInfo(name string) os.FileInfo {
 func (fs *mapFS) Lstat(ctx context.Context, path string) (os.FileInfo, error) {
 	path = filename(path)
 
+	// root is always exists.
+	if path == "" {
+		return dirInfo("/"), nil
+	}
+
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
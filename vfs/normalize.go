@@ -0,0 +1,273 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize returns a file system that normalizes the names of files into the Unicode normalization form.
+// macOS clients send the names in NFD, while most other clients send them in NFC,
+// so the same visible name may be stored as two different names.
+//
+// The returned file system creates files and directories with the names in form.
+// It looks up the names in any forms: if the name in form doesn't exist,
+// it searches the equivalent names in NFC, NFD and as is, component by component.
+// Overwriting a file writes to the existing equivalent file in the other form,
+// so the rules of fsys, e.g. no overwrite, apply to it.
+func Normalize(fsys FileSystem, form norm.Form) FileSystem {
+	if fsys == nil {
+		fsys = Null
+	}
+	return normalizer{FileSystem: fsys, form: form}
+}
+
+type normalizer struct {
+	FileSystem
+	form norm.Form
+}
+
+// candidates returns the equivalent forms of name, without duplicates.
+func (fsys normalizer) candidates(name string) []string {
+	ret := make([]string, 0, 4)
+	for _, s := range []string{fsys.form.String(name), name, norm.NFC.String(name), norm.NFD.String(name)} {
+		dup := false
+		for _, t := range ret {
+			if s == t {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// exists reports whether the named file exists.
+func (fsys normalizer) exists(ctx context.Context, name string) (bool, error) {
+	_, err := fsys.FileSystem.Lstat(ctx, name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// lookup returns the name of the existing file that is equivalent to name.
+func (fsys normalizer) lookup(ctx context.Context, name string) (string, error) {
+	name = pathpkg.Clean(name)
+	for _, cand := range fsys.candidates(name) {
+		if ok, err := fsys.exists(ctx, cand); err != nil {
+			return "", err
+		} else if ok {
+			return cand, nil
+		}
+	}
+
+	// the components of the path may be in different forms.
+	var resolved string
+	if pathpkg.IsAbs(name) {
+		resolved = "/"
+	}
+	for _, elem := range strings.Split(strings.Trim(name, "/"), "/") {
+		found := false
+		for _, cand := range fsys.candidates(elem) {
+			p := pathpkg.Join(resolved, cand)
+			if ok, err := fsys.exists(ctx, p); err != nil {
+				return "", err
+			} else if ok {
+				resolved = p
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", &os.PathError{
+				Op:   "lookup",
+				Path: name,
+				Err:  os.ErrNotExist,
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// do calls f with name in the normalization form.
+// If the file doesn't exist, it calls f again with the equivalent name in the file system.
+func (fsys normalizer) do(ctx context.Context, name string, f func(name string) error) error {
+	normalized := fsys.form.String(name)
+	err := f(normalized)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	found, lerr := fsys.lookup(ctx, name)
+	if lerr != nil || found == pathpkg.Clean(normalized) {
+		return err
+	}
+	return f(found)
+}
+
+// target returns the name for writing the file name.
+// If a file equivalent to name already exists, it is the name of the existing file.
+// Otherwise, it is the normalized name in the existing directory equivalent to the parent.
+// normalized is the latter in either case.
+func (fsys normalizer) target(ctx context.Context, name string) (target, normalized string, err error) {
+	dir, base := pathpkg.Split(pathpkg.Clean(name))
+	parent := dir
+	if dir != "" {
+		parent, err = fsys.lookup(ctx, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			parent, err = fsys.form.String(dir), nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	normalized = pathpkg.Join(parent, fsys.form.String(base))
+	for _, cand := range fsys.candidates(base) {
+		p := pathpkg.Join(parent, cand)
+		if ok, err := fsys.exists(ctx, p); err != nil {
+			return "", "", err
+		} else if ok {
+			return p, normalized, nil
+		}
+	}
+	return normalized, normalized, nil
+}
+
+func (fsys normalizer) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := fsys.do(ctx, name, func(name string) error {
+		var err error
+		r, err = fsys.FileSystem.Open(ctx, name)
+		return err
+	})
+	return r, err
+}
+
+func (fsys normalizer) OpenOffset(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := fsys.do(ctx, name, func(name string) error {
+		var err error
+		r, err = OpenOffset(ctx, fsys.FileSystem, name, offset)
+		return err
+	})
+	return r, err
+}
+
+func (fsys normalizer) Lstat(ctx context.Context, path string) (os.FileInfo, error) {
+	var stat os.FileInfo
+	err := fsys.do(ctx, path, func(name string) error {
+		var err error
+		stat, err = fsys.FileSystem.Lstat(ctx, name)
+		return err
+	})
+	return stat, err
+}
+
+func (fsys normalizer) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	var stat os.FileInfo
+	err := fsys.do(ctx, path, func(name string) error {
+		var err error
+		stat, err = fsys.FileSystem.Stat(ctx, name)
+		return err
+	})
+	return stat, err
+}
+
+func (fsys normalizer) ReadDir(ctx context.Context, path string) ([]os.FileInfo, error) {
+	normalized := fsys.form.String(path)
+	list, err := fsys.FileSystem.ReadDir(ctx, normalized)
+	if err == nil && len(list) > 0 || err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return list, err
+	}
+
+	// some file systems return an empty list for the directories that don't exist.
+	found, lerr := fsys.lookup(ctx, path)
+	if lerr != nil || found == pathpkg.Clean(normalized) {
+		return list, err
+	}
+	return fsys.FileSystem.ReadDir(ctx, found)
+}
+
+func (fsys normalizer) Hash(ctx context.Context, name, algorithm string) (string, error) {
+	var sum string
+	err := fsys.do(ctx, name, func(name string) error {
+		var err error
+		sum, err = Hash(ctx, fsys.FileSystem, name, algorithm)
+		return err
+	})
+	return sum, err
+}
+
+func (fsys normalizer) Create(ctx context.Context, name string, body io.Reader) error {
+	target, _, err := fsys.target(ctx, name)
+	if err != nil {
+		return err
+	}
+	return fsys.FileSystem.Create(ctx, target, body)
+}
+
+func (fsys normalizer) Mkdir(ctx context.Context, name string) error {
+	target, normalized, err := fsys.target(ctx, name)
+	if err != nil {
+		return err
+	}
+	if target != normalized {
+		return &os.PathError{
+			Op:   "mkdir",
+			Path: name,
+			Err:  os.ErrExist,
+		}
+	}
+	return fsys.FileSystem.Mkdir(ctx, target)
+}
+
+func (fsys normalizer) Remove(ctx context.Context, name string) error {
+	return fsys.do(ctx, name, func(name string) error {
+		return fsys.FileSystem.Remove(ctx, name)
+	})
+}
+
+func (fsys normalizer) Rename(ctx context.Context, oldname, newname, etag string) error {
+	target, normalized, err := fsys.target(ctx, newname)
+	if err != nil {
+		return err
+	}
+	return fsys.do(ctx, oldname, func(name string) error {
+		newname := target
+		if newname == pathpkg.Clean(name) {
+			// renaming the file into the normalization form.
+			newname = normalized
+		}
+		return Rename(ctx, fsys.FileSystem, name, newname, etag)
+	})
+}
+
+func (fsys normalizer) Chmod(ctx context.Context, name string, mode os.FileMode) error {
+	return fsys.do(ctx, name, func(name string) error {
+		return Chmod(ctx, fsys.FileSystem, name, mode)
+	})
+}
+
+func (fsys normalizer) Chtimes(ctx context.Context, name string, modTime, createTime time.Time) error {
+	return fsys.do(ctx, name, func(name string) error {
+		return Chtimes(ctx, fsys.FileSystem, name, modTime, createTime)
+	})
+}
+
+func (fsys normalizer) String() string {
+	return "normalized " + fsys.FileSystem.String()
+}
@@ -0,0 +1,90 @@
+package vfs_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shogo82148/s3ftpgateway/vfs"
+	"github.com/shogo82148/s3ftpgateway/vfs/mapfs"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalize(t *testing.T) {
+	ctx := context.Background()
+
+	// "café.txt" and "été/a.txt" uploaded by a macOS client.
+	nfdFile := norm.NFD.String("café.txt")
+	nfdDir := norm.NFD.String("été")
+	m := map[string]string{
+		nfdFile:           "NFD",
+		nfdDir + "/a.txt": "a",
+	}
+	fs := vfs.Normalize(mapfs.New(m), norm.NFC)
+
+	// the equivalent names are found.
+	r, err := fs.Open(ctx, "/café.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "NFD" {
+		t.Errorf("want %q, got %q", "NFD", data)
+	}
+	if _, err := fs.Stat(ctx, "/été/a.txt"); err != nil {
+		t.Error(err)
+	}
+	if _, err := fs.Stat(ctx, "/none.txt"); !os.IsNotExist(err) {
+		t.Errorf("want not exist error, got %v", err)
+	}
+
+	// overwriting writes to the existing file in NFD.
+	if err := fs.Create(ctx, "/café.txt", strings.NewReader("NFC")); err != nil {
+		t.Fatal(err)
+	}
+	if m[nfdFile] != "NFC" {
+		t.Errorf("the file in NFD is not overwritten: %v", m)
+	}
+	if _, ok := m[norm.NFC.String("café.txt")]; ok {
+		t.Errorf("the file in NFC is created")
+	}
+
+	// the new files are created in the existing directory.
+	if err := fs.Create(ctx, "/été/b.txt", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	if m[nfdDir+"/b.txt"] != "b" {
+		t.Errorf("the file is not created in the existing directory: %v", m)
+	}
+	list, err := fs.ReadDir(ctx, "/été")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("want 2 entries, got %d", len(list))
+	}
+	if err := fs.Mkdir(ctx, "/été"); !os.IsExist(err) {
+		t.Errorf("want exist error, got %v", err)
+	}
+
+	// the new names are normalized.
+	if err := fs.Create(ctx, "/"+norm.NFD.String("naïve.txt"), strings.NewReader("naive")); err != nil {
+		t.Fatal(err)
+	}
+	if m[norm.NFC.String("naïve.txt")] != "naive" {
+		t.Errorf("the name is not normalized: %v", m)
+	}
+
+	if err := fs.Remove(ctx, "/été/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m[nfdDir+"/a.txt"]; ok {
+		t.Errorf("the file is not removed")
+	}
+}
@@ -0,0 +1,38 @@
+package vfs
+
+import (
+	"context"
+	"io"
+)
+
+// OffsetFileSystem is the interface implemented by a file system
+// that can open files from the middle.
+type OffsetFileSystem interface {
+	FileSystem
+
+	// OpenOffset opens the named file, and skips the first offset bytes.
+	// If offset is larger than the size of the file, the reader returns no data.
+	OpenOffset(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+}
+
+// OpenOffset opens the named file, and skips the first offset bytes.
+// If fs implements OffsetFileSystem, OpenOffset calls fs.OpenOffset.
+// Otherwise, OpenOffset reads and discards the first offset bytes.
+func OpenOffset(ctx context.Context, fs FileSystem, name string, offset int64) (io.ReadCloser, error) {
+	if offset <= 0 {
+		return fs.Open(ctx, name)
+	}
+	if fs, ok := fs.(OffsetFileSystem); ok {
+		return fs.OpenOffset(ctx, name, offset)
+	}
+
+	r, err := fs.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"time"
 )
 
 // ReadOnly makes fs read only.
@@ -45,6 +46,14 @@ func (fs readonly) ReadDir(ctx context.Context, path string) ([]os.FileInfo, err
 	return stats, nil
 }
 
+func (fs readonly) Hash(ctx context.Context, name, algorithm string) (string, error) {
+	return Hash(ctx, fs.FileSystem, name, algorithm)
+}
+
+func (fs readonly) OpenOffset(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	return OpenOffset(ctx, fs.FileSystem, name, offset)
+}
+
 func (fs readonly) Create(ctx context.Context, name string, body io.Reader) error {
 	return &os.PathError{
 		Op:   "create",
@@ -53,6 +62,30 @@ func (fs readonly) Create(ctx context.Context, name string, body io.Reader) erro
 	}
 }
 
+func (fs readonly) Rename(ctx context.Context, oldname, newname, etag string) error {
+	return &os.PathError{
+		Op:   "rename",
+		Path: oldname,
+		Err:  os.ErrPermission,
+	}
+}
+
+func (fs readonly) Chmod(ctx context.Context, name string, mode os.FileMode) error {
+	return &os.PathError{
+		Op:   "chmod",
+		Path: name,
+		Err:  os.ErrPermission,
+	}
+}
+
+func (fs readonly) Chtimes(ctx context.Context, name string, modTime, createTime time.Time) error {
+	return &os.PathError{
+		Op:   "chtimes",
+		Path: name,
+		Err:  os.ErrPermission,
+	}
+}
+
 func (fs readonly) Mkdir(ctx context.Context, name string) error {
 	return &os.PathError{
 		Op:   "mkdir",
@@ -78,3 +111,19 @@ type readonlyStat struct {
 func (stat readonlyStat) Mode() os.FileMode {
 	return stat.FileInfo.Mode() &^ 0222
 }
+
+func (stat readonlyStat) ETag() string {
+	return ETag(stat.FileInfo)
+}
+
+func (stat readonlyStat) CreateTime() time.Time {
+	return CreateTime(stat.FileInfo)
+}
+
+func (stat readonlyStat) Owner() (string, string) {
+	return Owner(stat.FileInfo)
+}
+
+func (stat readonlyStat) ContentType() string {
+	return ContentType(stat.FileInfo)
+}
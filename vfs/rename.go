@@ -0,0 +1,72 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// RenameFileSystem is the interface implemented by a file system
+// that can rename files by itself.
+type RenameFileSystem interface {
+	FileSystem
+
+	// Rename renames the file oldname to newname.
+	// If etag is not empty, Rename fails with an error that wraps ErrConflict
+	// when the entity tag of oldname is not etag.
+	// If the file system can't rename the file,
+	// Rename returns an error that wraps errors.ErrUnsupported.
+	Rename(ctx context.Context, oldname, newname, etag string) error
+}
+
+// Rename renames the file oldname to newname.
+// If fs implements RenameFileSystem, Rename calls fs.Rename.
+// Otherwise, or if fs.Rename doesn't support the file,
+// Rename copies oldname to newname and removes oldname.
+//
+// If etag is not empty, it is compared with the entity tag of oldname,
+// and Rename fails with an error that wraps ErrConflict if they differ.
+// It is typically the result of ETag for the FileInfo
+// that the caller got before.
+func Rename(ctx context.Context, fs FileSystem, oldname, newname, etag string) error {
+	if fs, ok := fs.(RenameFileSystem); ok {
+		err := fs.Rename(ctx, oldname, newname, etag)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	if etag != "" {
+		stat, err := fs.Stat(ctx, oldname)
+		if err != nil {
+			return err
+		}
+		if ETag(stat) != etag {
+			return &os.PathError{
+				Op:   "rename",
+				Path: oldname,
+				Err:  ErrConflict,
+			}
+		}
+	}
+
+	r, err := fs.Open(ctx, oldname)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := fs.Create(ctx, newname, r); err != nil {
+		return err
+	}
+	return fs.Remove(ctx, oldname)
+}
+
+// ETag returns the entity tag of the file.
+// The entity tag changes whenever the content of the file changes.
+// If fi doesn't have the ETag method, ETag returns an empty string.
+func ETag(fi os.FileInfo) string {
+	if fi, ok := fi.(interface{ ETag() string }); ok {
+		return fi.ETag()
+	}
+	return ""
+}
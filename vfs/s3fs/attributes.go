@@ -0,0 +1,166 @@
+package s3fs
+
+import (
+	"context"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// UploadRule is a rule for uploaded objects.
+type UploadRule struct {
+	// User is the name of the user that the rule is applied to.
+	// If it is empty, the rule is applied to all users.
+	User string
+
+	// PathPrefix is the prefix of the paths that the rule is applied to, e.g. "/incoming".
+	// If it is empty, the rule is applied to all paths.
+	PathPrefix string
+
+	// Tags are the templates of the object tags.
+	// The templates are executed with TemplateData.
+	Tags map[string]*template.Template
+
+	// Metadata are the templates of the user-defined metadata.
+	// The templates are executed with TemplateData.
+	Metadata map[string]*template.Template
+
+	// NoOverwrite prohibits replacing existing objects.
+	// Create and Rename fail with vfs.ErrConflict if the destination already exists.
+	NoOverwrite bool
+}
+
+// TemplateData is the data passed to the templates of UploadRule.
+type TemplateData struct {
+	// User is the name of the user who uploads the file.
+	User string
+
+	// SessionID is the identifier of the session.
+	SessionID string
+
+	// ClientIP is the IP address of the client.
+	ClientIP string
+
+	// Listener is the name of the listener that accepted the session.
+	Listener string
+
+	// Path is the path of the uploaded file.
+	Path string
+
+	// Time is the time when the upload started, in UTC.
+	Time time.Time
+}
+
+func (rule *UploadRule) match(user, name string) bool {
+	if rule.User != "" && rule.User != user {
+		return false
+	}
+	if rule.PathPrefix == "" {
+		return true
+	}
+	prefix := pathpkg.Clean("/" + rule.PathPrefix)
+	if prefix == "/" {
+		return true
+	}
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// uploadAttributes returns the object tags and the user-defined metadata for the named file.
+// All matching rules are applied in order, and later rules override earlier ones.
+func (fs *FileSystem) uploadAttributes(ctx context.Context, name string) (tags, metadata map[string]string, err error) {
+	if len(fs.UploadRules) == 0 {
+		return nil, nil, nil
+	}
+
+	session := vfs.SessionFromContext(ctx)
+	name = pathpkg.Clean("/" + name)
+	data := &TemplateData{
+		User:      session.User,
+		SessionID: session.ID,
+		ClientIP:  session.ClientIP,
+		Listener:  session.Listener,
+		Path:      name,
+		Time:      time.Now().UTC(),
+	}
+	execute := func(dst map[string]string, templates map[string]*template.Template) (map[string]string, error) {
+		for k, tmpl := range templates {
+			var buf strings.Builder
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return nil, &os.PathError{
+					Op:   "create",
+					Path: filename(name),
+					Err:  err,
+				}
+			}
+			if dst == nil {
+				dst = make(map[string]string)
+			}
+			dst[k] = buf.String()
+		}
+		return dst, nil
+	}
+
+	for i := range fs.UploadRules {
+		rule := &fs.UploadRules[i]
+		if !rule.match(session.User, name) {
+			continue
+		}
+		if tags, err = execute(tags, rule.Tags); err != nil {
+			return nil, nil, err
+		}
+		if metadata, err = execute(metadata, rule.Metadata); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tags, metadata, nil
+}
+
+// noOverwrite reports whether any matching rule prohibits replacing the named file.
+func (fs *FileSystem) noOverwrite(ctx context.Context, name string) bool {
+	session := vfs.SessionFromContext(ctx)
+	name = pathpkg.Clean("/" + name)
+	for i := range fs.UploadRules {
+		rule := &fs.UploadRules[i]
+		if rule.NoOverwrite && rule.match(session.User, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeTagging encodes the tags in the format of the x-amz-tagging header.
+func encodeTagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	v := make(url.Values, len(tags))
+	for k, value := range tags {
+		v.Set(k, value)
+	}
+	return aws.String(v.Encode())
+}
+
+// tagSet converts the tags into types.Tag sorted by keys.
+func tagSet(tags map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	set := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		set = append(set, types.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return set
+}
@@ -0,0 +1,170 @@
+package s3fs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// hashAlgorithm converts the checksum algorithm of S3 to the name of vfs.
+func hashAlgorithm(algorithm types.ChecksumAlgorithm) string {
+	switch algorithm {
+	case types.ChecksumAlgorithmSha256:
+		return vfs.HashSHA256
+	case types.ChecksumAlgorithmCrc32c:
+		return vfs.HashCRC32C
+	}
+	return ""
+}
+
+// checksumTagKey returns the key of the object tag
+// that records the checksum of the whole object.
+func checksumTagKey(algorithm string) string {
+	return "s3ftpgateway-checksum-" + strings.ToLower(strings.ReplaceAll(algorithm, "-", ""))
+}
+
+// isCompositeChecksum reports whether the checksum is a checksum of checksums.
+// S3 returns them for objects uploaded by multipart uploads, e.g. "base64-encoded-checksum-3".
+func isCompositeChecksum(checksum string) bool {
+	return strings.Contains(checksum, "-")
+}
+
+// verifyChecksum compares the checksum calculated by the gateway with the checksum calculated by S3.
+// S3 can't calculate the checksum of the whole object for multipart uploads,
+// so verifyChecksum records it as an object tag instead.
+// PutObjectTagging replaces all tags of the object, so tags must contain the tags set on uploading.
+func (fs *FileSystem) verifyChecksum(ctx context.Context, name string, out *manager.UploadOutput, sum []byte, tags map[string]string) error {
+	algorithm := hashAlgorithm(fs.ChecksumAlgorithm)
+	var stored string
+	switch fs.ChecksumAlgorithm {
+	case types.ChecksumAlgorithmSha256:
+		stored = aws.ToString(out.ChecksumSHA256)
+	case types.ChecksumAlgorithmCrc32c:
+		stored = aws.ToString(out.ChecksumCRC32C)
+	}
+
+	if stored != "" && !isCompositeChecksum(stored) {
+		if stored == base64.StdEncoding.EncodeToString(sum) {
+			return nil
+		}
+		// the object is broken. remove it.
+		fs.Remove(context.WithoutCancel(ctx), name)
+		return &os.PathError{
+			Op:   "create",
+			Path: filename(name),
+			Err:  fmt.Errorf("s3fs: checksum mismatch: %s", algorithm),
+		}
+	}
+
+	all := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		all[k] = v
+	}
+	all[checksumTagKey(algorithm)] = hex.EncodeToString(sum)
+
+	svc := fs.s3()
+	_, err := svc.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket: aws.String(fs.Bucket),
+		Key:    aws.String(fs.filekey(name)),
+		Tagging: &types.Tagging{
+			TagSet: tagSet(all),
+		},
+	})
+	if err != nil {
+		return &os.PathError{
+			Op:   "create",
+			Path: filename(name),
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// etagMD5 returns the MD5 digest of the object from its ETag.
+// The ETag is the MD5 digest only if the object is uploaded by a single PUT,
+// and isn't encrypted with SSE-KMS or SSE-C.
+func etagMD5(head *s3.HeadObjectOutput) (string, bool) {
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	if len(etag) != hex.EncodedLen(md5.Size) || isCompositeChecksum(etag) {
+		return "", false
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return "", false
+	}
+	switch head.ServerSideEncryption {
+	case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+		return "", false
+	}
+	if aws.ToString(head.SSECustomerAlgorithm) != "" {
+		return "", false
+	}
+	return strings.ToLower(etag), true
+}
+
+// Hash returns the checksum of the named file, encoded in lower-case hex.
+// The checksums are available for the files uploaded with ChecksumAlgorithm.
+// MD5 digests are also available from the ETags of the objects uploaded by a single PUT.
+func (fs *FileSystem) Hash(ctx context.Context, name, algorithm string) (string, error) {
+	head, err := fs.headObject(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	var stored string
+	switch algorithm {
+	case vfs.HashCRC32:
+		stored = aws.ToString(head.ChecksumCRC32)
+	case vfs.HashCRC32C:
+		stored = aws.ToString(head.ChecksumCRC32C)
+	case vfs.HashSHA1:
+		stored = aws.ToString(head.ChecksumSHA1)
+	case vfs.HashSHA256:
+		stored = aws.ToString(head.ChecksumSHA256)
+	case vfs.HashMD5:
+		if sum, ok := etagMD5(head); ok {
+			return sum, nil
+		}
+	}
+	if stored != "" && !isCompositeChecksum(stored) {
+		sum, err := base64.StdEncoding.DecodeString(stored)
+		if err == nil {
+			return hex.EncodeToString(sum), nil
+		}
+	}
+
+	// the object may be uploaded by a multipart upload.
+	svc := fs.s3()
+	resp, err := svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(fs.Bucket),
+		Key:    aws.String(fs.filekey(name)),
+	})
+	if err != nil {
+		return "", &os.PathError{
+			Op:   "hash",
+			Path: filename(name),
+			Err:  err,
+		}
+	}
+	key := checksumTagKey(algorithm)
+	for _, tag := range resp.TagSet {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value), nil
+		}
+	}
+	return "", &os.PathError{
+		Op:   "hash",
+		Path: filename(name),
+		Err:  errors.ErrUnsupported,
+	}
+}
@@ -0,0 +1,139 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// the number of attempts to download a range.
+// the SDK retries failed requests, but it doesn't retry broken response bodies.
+var downloadAttempts = 3
+
+func (fs *FileSystem) downloadPartSize() int64 {
+	if fs.DownloadPartSize > 0 {
+		return fs.DownloadPartSize
+	}
+	return manager.DefaultDownloadPartSize
+}
+
+// rangePart is a part of the object.
+type rangePart struct {
+	data []byte
+	err  error
+}
+
+// getRange downloads the range [offset, offset+size) of the object.
+func (fs *FileSystem) getRange(ctx context.Context, key, etag string, offset, size int64) ([]byte, error) {
+	var lastErr error
+	for i := 0; i < downloadAttempts; i++ {
+		data, err := fs.getRangeOnce(ctx, key, etag, offset, size)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			// client errors, such as 404 Not Found and 412 Precondition Failed, are not temporary.
+			if code := respErr.HTTPStatusCode(); code >= 400 && code < 500 {
+				break
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+func (fs *FileSystem) getRangeOnce(ctx context.Context, key, etag string, offset, size int64) ([]byte, error) {
+	svc := fs.s3()
+	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(fs.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)),
+
+		// the object must not be changed while downloading.
+		IfMatch: aws.String(etag),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf := bytes.NewBuffer(make([]byte, 0, aws.ToInt64(resp.ContentLength)))
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openParallel opens the object from offset, and downloads it by ranged GET requests in parallel.
+// total and etag are the size and the ETag of the object.
+func (fs *FileSystem) openParallel(ctx context.Context, key, etag string, offset, total int64) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &parallelReader{
+		cancel: cancel,
+		parts:  make(chan chan rangePart, fs.DownloadConcurrency),
+	}
+	go r.download(ctx, fs, key, etag, offset, fs.downloadPartSize(), total)
+	return r
+}
+
+// parallelReader reads the parts of the object in order.
+type parallelReader struct {
+	cancel context.CancelFunc
+
+	// parts is the queue of the parts that are being downloaded.
+	// its capacity limits the concurrency and the memory usage.
+	parts chan chan rangePart
+
+	buf []byte
+	err error
+}
+
+func (r *parallelReader) download(ctx context.Context, fs *FileSystem, key, etag string, offset, partSize, total int64) {
+	defer close(r.parts)
+	for ; offset < total; offset += partSize {
+		ch := make(chan rangePart, 1)
+		select {
+		case r.parts <- ch:
+		case <-ctx.Done():
+			return
+		}
+		go func(offset int64) {
+			data, err := fs.getRange(ctx, key, etag, offset, partSize)
+			ch <- rangePart{data: data, err: err}
+		}(offset)
+	}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		ch, ok := <-r.parts
+		if !ok {
+			r.err = io.EOF
+			continue
+		}
+		part := <-ch
+		r.buf, r.err = part.data, part.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *parallelReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,192 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// the keys of the user-defined metadata for the file attributes.
+// They are compatible with s3fs-fuse https://github.com/s3fs-fuse/s3fs-fuse
+const (
+	metadataMode  = "mode"  // st_mode in decimal
+	metadataMtime = "mtime" // the modification time in unix seconds
+	metadataBtime = "btime" // the creation time in unix seconds, same as rclone
+	metadataUID   = "uid"   // the owner
+	metadataGID   = "gid"   // the group
+)
+
+// the number of HeadObject requests in parallel for ListMetadata.
+const listMetadataConcurrency = 16
+
+// the file type bits of regular files in st_mode.
+const modeRegular = 0100000
+
+// Chmod changes the mode of the named file.
+// The mode is saved in the user-defined metadata of the object.
+func (fs *FileSystem) Chmod(ctx context.Context, name string, mode os.FileMode) error {
+	return fs.updateMetadata(ctx, "chmod", name, func(metadata map[string]string) {
+		metadata[metadataMode] = strconv.FormatUint(uint64(modeRegular|mode.Perm()), 10)
+	})
+}
+
+// Chtimes changes the modification time and the creation time of the named file.
+// The times are saved in the user-defined metadata of the object,
+// because S3 doesn't allow changing the Last-Modified of objects.
+func (fs *FileSystem) Chtimes(ctx context.Context, name string, modTime, createTime time.Time) error {
+	return fs.updateMetadata(ctx, "chtimes", name, func(metadata map[string]string) {
+		if !modTime.IsZero() {
+			metadata[metadataMtime] = strconv.FormatInt(modTime.Unix(), 10)
+		}
+		if !createTime.IsZero() {
+			metadata[metadataBtime] = strconv.FormatInt(createTime.Unix(), 10)
+		}
+	})
+}
+
+// updateMetadata replaces the user-defined metadata of the named object by copying the object onto itself.
+// Directories and objects larger than 5 GiB are not supported.
+func (fs *FileSystem) updateMetadata(ctx context.Context, op, name string, update func(metadata map[string]string)) error {
+	stat, err := fs.Lstat(ctx, name)
+	if err != nil {
+		return err
+	}
+	obj, ok := stat.(object)
+	if !ok || obj.Size() > maxCopySize {
+		return &os.PathError{
+			Op:   op,
+			Path: filename(name),
+			Err:  errors.ErrUnsupported,
+		}
+	}
+
+	head, err := fs.headObject(ctx, name)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	update(metadata)
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(fs.Bucket),
+		Key:               aws.String(fs.filekey(name)),
+		CopySource:        fs.copySource(name),
+		CopySourceIfMatch: head.ETag,
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          metadata,
+
+		// the system-defined metadata are also replaced, so copy them.
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+	}
+	if aws.ToString(head.ChecksumSHA256) != "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	} else if aws.ToString(head.ChecksumCRC32C) != "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32c
+	}
+	svc := fs.s3()
+	if _, err := svc.CopyObject(ctx, input); err != nil {
+		if isConflict(err) {
+			err = vfs.ErrConflict
+		}
+		return &os.PathError{
+			Op:   op,
+			Path: filename(name),
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// copySource returns the value of the x-amz-copy-source header for the named file.
+func (fs *FileSystem) copySource(name string) *string {
+	return aws.String(url.PathEscape(fs.Bucket) + "/" + url.PathEscape(fs.filekey(name)))
+}
+
+// headMode returns the permission bits saved by Chmod.
+func headMode(head *s3.HeadObjectOutput) (os.FileMode, bool) {
+	if head == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(head.Metadata[metadataMode], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(v).Perm(), true
+}
+
+// headModTime returns the modification time saved by Chtimes.
+func headModTime(head *s3.HeadObjectOutput) (time.Time, bool) {
+	return headTime(head, metadataMtime)
+}
+
+// headCreateTime returns the creation time saved by Chtimes.
+func headCreateTime(head *s3.HeadObjectOutput) (time.Time, bool) {
+	return headTime(head, metadataBtime)
+}
+
+func headTime(head *s3.HeadObjectOutput, key string) (time.Time, bool) {
+	if head == nil {
+		return time.Time{}, false
+	}
+	v, err := strconv.ParseInt(head.Metadata[key], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(v, 0), true
+}
+
+// listMetadata gets the metadata of the objects in the listing by HeadObject.
+func (fs *FileSystem) listMetadata(ctx context.Context, dir string, list []os.FileInfo) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, listMetadataConcurrency)
+	for i, fi := range list {
+		obj, ok := fi.(object)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, obj object) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			head, err := fs.headObject(ctx, pathpkg.Join(dir, obj.Name()))
+			if err != nil {
+				if os.IsNotExist(err) {
+					// the object is removed after listing.
+					return
+				}
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			obj.head = head
+			list[i] = obj
+		}(i, obj)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,76 @@
+package s3fs
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/text/unicode/norm"
+)
+
+// FindNormalizationCollisions searches the files and the directories under Prefix
+// whose names are equal in the Unicode normalization form, e.g. the same name in NFC and NFD.
+// They are left by the clients that send the names in different forms, before normalizing names with vfs.Normalize.
+// It returns the groups of the colliding names, relative to Prefix.
+// The names of directories end with a slash.
+func (fs *FileSystem) FindNormalizationCollisions(ctx context.Context, form norm.Form) ([][]string, error) {
+	svc := fs.s3()
+	prefix := fs.dirkey("")
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(fs.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	// normalized name -> the names in the bucket
+	names := map[string]map[string]struct{}{}
+	add := func(name string) {
+		normalized := form.String(name)
+		if names[normalized] == nil {
+			names[normalized] = map[string]struct{}{}
+		}
+		names[normalized][name] = struct{}{}
+	}
+	for {
+		resp, err := svc.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range resp.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			add(name)
+
+			// the directories that contain the object.
+			for i := strings.IndexByte(name, '/'); i >= 0 && i < len(name)-1; {
+				add(name[:i+1])
+				j := strings.IndexByte(name[i+1:], '/')
+				if j < 0 {
+					break
+				}
+				i += j + 1
+			}
+		}
+		if !aws.ToBool(resp.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+
+	var groups [][]string
+	for _, set := range names {
+		if len(set) < 2 {
+			continue
+		}
+		group := make([]string, 0, len(set))
+		for name := range set {
+			group = append(group, name)
+		}
+		sort.Strings(group)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups, nil
+}
@@ -0,0 +1,80 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/shogo82148/s3ftpgateway/vfs"
+)
+
+// the maximum size of objects that CopyObject can copy.
+var maxCopySize int64 = 5 * 1024 * 1024 * 1024
+
+// isConflict reports whether err is the failure of a conditional request.
+func isConflict(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return true
+		}
+	}
+	return false
+}
+
+// Rename renames the file oldname to newname by copying the object on S3.
+// If etag is not empty, the object is copied only if its ETag is etag.
+// Directories and objects larger than 5 GiB are not supported.
+func (fs *FileSystem) Rename(ctx context.Context, oldname, newname, etag string) error {
+	stat, err := fs.Lstat(ctx, oldname)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() || stat.Size() > maxCopySize {
+		return &os.PathError{
+			Op:   "rename",
+			Path: filename(oldname),
+			Err:  errors.ErrUnsupported,
+		}
+	}
+
+	if fs.noOverwrite(ctx, newname) {
+		// CopyObject doesn't support If-None-Match,
+		// so there is a small window that another session creates newname.
+		if _, err := fs.Lstat(ctx, newname); err == nil {
+			return &os.PathError{
+				Op:   "rename",
+				Path: filename(newname),
+				Err:  vfs.ErrConflict,
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(fs.Bucket),
+		Key:        aws.String(fs.filekey(newname)),
+		CopySource: fs.copySource(oldname),
+	}
+	if etag != "" {
+		input.CopySourceIfMatch = aws.String(etag)
+	}
+	svc := fs.s3()
+	if _, err := svc.CopyObject(ctx, input); err != nil {
+		if isConflict(err) {
+			err = vfs.ErrConflict
+		}
+		return &os.PathError{
+			Op:   "rename",
+			Path: filename(oldname),
+			Err:  err,
+		}
+	}
+	return fs.Remove(ctx, oldname)
+}
@@ -3,6 +3,8 @@ package s3fs
 import (
 	"context"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net/http"
@@ -17,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/shogo82148/s3ftpgateway/vfs"
 )
 
 type s3client interface {
@@ -24,7 +27,12 @@ type s3client interface {
 	manager.UploadAPIClient
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
 }
 type uploaderClient interface {
 	Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error)
@@ -36,6 +44,52 @@ type FileSystem struct {
 	Bucket string
 	Prefix string
 
+	// PartSize is the size in bytes of each part of multipart uploads.
+	// If it is zero, manager.DefaultUploadPartSize is used.
+	PartSize int64
+
+	// Concurrency is the number of parts uploaded in parallel per file.
+	// If it is zero, manager.DefaultUploadConcurrency is used.
+	Concurrency int
+
+	// BufferSize enables pooling of the buffers used for sending parts,
+	// and it is the size in bytes of each buffer.
+	// If it is zero, the default strategy of the SDK is used.
+	BufferSize int
+
+	// DownloadConcurrency is the number of ranges of an object
+	// that Open downloads in parallel.
+	// If it is less than two, Open downloads objects by a single request.
+	DownloadConcurrency int
+
+	// DownloadPartSize is the size in bytes of each range downloaded in parallel.
+	// If it is zero, manager.DefaultDownloadPartSize is used.
+	DownloadPartSize int64
+
+	// DownloadThreshold is the minimum size in bytes of objects downloaded in parallel.
+	// Open gets the size by HeadObject, and downloads smaller objects by a single request.
+	DownloadThreshold int64
+
+	// MaxUploadSize is the maximum size in bytes of uploaded files.
+	// Create fails with vfs.ErrFileTooLarge if the body exceeds it.
+	// If it is zero, the size is not limited.
+	MaxUploadSize int64
+
+	// ChecksumAlgorithm is the algorithm for verifying uploaded files.
+	// The checksum is calculated while uploading, and S3 validates it.
+	// types.ChecksumAlgorithmCrc32c and types.ChecksumAlgorithmSha256 are supported.
+	// If it is empty, the checksum is not calculated.
+	ChecksumAlgorithm types.ChecksumAlgorithm
+
+	// UploadRules are the rules for the object tags and the user-defined metadata of uploaded objects.
+	UploadRules []UploadRule
+
+	// ListMetadata makes ReadDir get the user-defined metadata of each object by HeadObject,
+	// so listings show the modes and the times set by Chmod and Chtimes.
+	// It costs a HEAD request per object.
+	// ReadDir also gets them if the context is from vfs.WithMetadata, regardless of ListMetadata.
+	ListMetadata bool
+
 	mu          sync.Mutex
 	s3api       s3client
 	uploaderapi uploaderClient
@@ -85,45 +139,92 @@ func (fs *FileSystem) uploader() uploaderClient {
 	defer fs.mu.Unlock()
 
 	if fs.uploaderapi == nil {
-		fs.uploaderapi = manager.NewUploader(s3)
+		fs.uploaderapi = manager.NewUploader(uploadTracker{s3}, func(u *manager.Uploader) {
+			if fs.PartSize > 0 {
+				u.PartSize = fs.PartSize
+			}
+			if fs.Concurrency > 0 {
+				u.Concurrency = fs.Concurrency
+			}
+			if fs.BufferSize > 0 {
+				u.BufferProvider = manager.NewBufferedReadSeekerWriteToPool(fs.BufferSize)
+			}
+
+			// the uploader aborts multipart uploads with the context of Upload,
+			// but it may be already canceled. abortUpload does it instead.
+			u.LeavePartsOnError = true
+		})
 	}
 	return fs.uploaderapi
 }
 
 // Open opens the file.
 func (fs *FileSystem) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return fs.OpenOffset(ctx, name, 0)
+}
+
+// OpenOffset opens the file, and skips the first offset bytes by ranged GET requests.
+func (fs *FileSystem) OpenOffset(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
 	svc := fs.s3()
-	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
+	if fs.DownloadConcurrency > 1 {
+		// the object is downloaded in parallel only if it is large enough.
+		head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(fs.Bucket),
+			Key:    aws.String(fs.filekey(name)),
+		})
+		if err != nil {
+			return nil, openError(name, err)
+		}
+		total := aws.ToInt64(head.ContentLength)
+		if total > offset && total-offset >= fs.DownloadThreshold {
+			return fs.openParallel(ctx, fs.filekey(name), aws.ToString(head.ETag), offset, total), nil
+		}
+	}
+
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(fs.Bucket),
 		Key:    aws.String(fs.filekey(name)),
-	})
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := svc.GetObject(ctx, input)
 	if err != nil {
 		var respErr *awshttp.ResponseError
-		if errors.As(err, &respErr) {
-			switch respErr.HTTPStatusCode() {
-			case http.StatusNotFound:
-				return nil, &os.PathError{
-					Op:   "open",
-					Path: filename(name),
-					Err:  os.ErrNotExist,
-				}
-			case http.StatusForbidden:
-				return nil, &os.PathError{
-					Op:   "open",
-					Path: filename(name),
-					Err:  os.ErrPermission,
-				}
-			}
-		}
-		return nil, &os.PathError{
-			Op:   "open",
-			Path: filename(name),
-			Err:  err,
+		if offset > 0 && errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable {
+			// the offset is beyond the end of the file.
+			return io.NopCloser(strings.NewReader("")), nil
 		}
+		return nil, openError(name, err)
 	}
 	return resp.Body, nil
 }
 
+func openError(name string, err error) error {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return &os.PathError{
+				Op:   "open",
+				Path: filename(name),
+				Err:  os.ErrNotExist,
+			}
+		case http.StatusForbidden:
+			return &os.PathError{
+				Op:   "open",
+				Path: filename(name),
+				Err:  os.ErrPermission,
+			}
+		}
+	}
+	return &os.PathError{
+		Op:   "open",
+		Path: filename(name),
+		Err:  err,
+	}
+}
+
 // Lstat returns a FileInfo describing the named file.
 func (fs *FileSystem) Lstat(ctx context.Context, path string) (os.FileInfo, error) {
 	// root is always exists.
@@ -169,7 +270,7 @@ func (fs *FileSystem) Lstat(ctx context.Context, path string) (os.FileInfo, erro
 		return commonPrefix{resp.CommonPrefixes[0]}, nil
 	}
 	if len(resp.Contents) > 0 && aws.ToString(resp.Contents[0].Key) == file {
-		return object{resp.Contents[0]}, nil
+		return object{obj: resp.Contents[0]}, nil
 	}
 	return nil, &os.PathError{
 		Op:   "stat",
@@ -179,12 +280,80 @@ func (fs *FileSystem) Lstat(ctx context.Context, path string) (os.FileInfo, erro
 }
 
 // Stat returns a FileInfo describing the named file. If there is an error, it will be of type *PathError.
+// If ctx is from vfs.WithMetadata, it also gets the user-defined metadata of files by HeadObject,
+// e.g. the modes and the times set by Chmod and Chtimes. Otherwise it is same as Lstat.
 func (fs *FileSystem) Stat(ctx context.Context, path string) (os.FileInfo, error) {
-	return fs.Lstat(ctx, path)
+	stat, err := fs.Lstat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := stat.(object)
+	if !ok || !vfs.MetadataFromContext(ctx) {
+		return stat, nil
+	}
+	head, err := fs.headObject(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	obj.head = head
+	return obj, nil
+}
+
+func (fs *FileSystem) headObject(ctx context.Context, path string) (*s3.HeadObjectOutput, error) {
+	svc := fs.s3()
+	resp, err := svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(fs.Bucket),
+		Key:          aws.String(fs.filekey(path)),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.HTTPStatusCode() {
+			case http.StatusNotFound:
+				return nil, &os.PathError{
+					Op:   "stat",
+					Path: filename(path),
+					Err:  os.ErrNotExist,
+				}
+			case http.StatusForbidden:
+				return nil, &os.PathError{
+					Op:   "stat",
+					Path: filename(path),
+					Err:  os.ErrPermission,
+				}
+			}
+		}
+		return nil, &os.PathError{
+			Op:   "stat",
+			Path: filename(path),
+			Err:  err,
+		}
+	}
+	return resp, nil
+}
+
+// ObjectAttributes are attributes of an object.
+// The Sys method of FileInfo of files returns *ObjectAttributes.
+// It returned types.Object in the previous versions.
+type ObjectAttributes struct {
+	Key  string
+	ETag string
+
+	// The following fields are available only in the results of Stat with vfs.WithMetadata.
+
+	ContentType string
+	Metadata    map[string]string
+
+	// ChecksumCRC32C and ChecksumSHA256 are base64-encoded checksums calculated by S3.
+	// They are the checksums of checksums of parts for multipart uploads, e.g. "base64-encoded-checksum-3".
+	ChecksumCRC32C string
+	ChecksumSHA256 string
 }
 
 type object struct {
-	obj types.Object
+	obj  types.Object
+	head *s3.HeadObjectOutput // it is nil unless the metadata are requested, see vfs.WithMetadata.
 }
 
 func (obj object) Name() string {
@@ -195,18 +364,69 @@ func (obj object) Size() int64 {
 	return aws.ToInt64(obj.obj.Size)
 }
 func (obj object) Mode() os.FileMode {
+	if mode, ok := headMode(obj.head); ok {
+		return mode
+	}
 	return 0644
 }
 func (obj object) ModTime() time.Time {
+	if mtime, ok := headModTime(obj.head); ok {
+		return mtime
+	}
 	return aws.ToTime(obj.obj.LastModified)
 }
 
+// CreateTime returns the creation time set by Chtimes.
+// If it is not set, CreateTime returns the zero time.Time value.
+func (obj object) CreateTime() time.Time {
+	if btime, ok := headCreateTime(obj.head); ok {
+		return btime
+	}
+	return time.Time{}
+}
+
+// Owner returns the owner and the group saved in the user-defined metadata.
+// They are numeric IDs if the object is uploaded by s3fs-fuse.
+func (obj object) Owner() (string, string) {
+	if obj.head == nil {
+		return "", ""
+	}
+	return obj.head.Metadata[metadataUID], obj.head.Metadata[metadataGID]
+}
+
+// ContentType returns the Content-Type of the object.
+// It is empty unless the metadata are requested, see vfs.WithMetadata and ListMetadata.
+func (obj object) ContentType() string {
+	if obj.head == nil {
+		return ""
+	}
+	return aws.ToString(obj.head.ContentType)
+}
+
 func (obj object) IsDir() bool {
 	return false
 }
 
+// ETag returns the entity tag of the object.
+func (obj object) ETag() string {
+	if obj.head != nil {
+		return aws.ToString(obj.head.ETag)
+	}
+	return aws.ToString(obj.obj.ETag)
+}
+
 func (obj object) Sys() interface{} {
-	return obj.obj
+	attrs := &ObjectAttributes{
+		Key:  aws.ToString(obj.obj.Key),
+		ETag: aws.ToString(obj.obj.ETag),
+	}
+	if head := obj.head; head != nil {
+		attrs.ContentType = aws.ToString(head.ContentType)
+		attrs.Metadata = head.Metadata
+		attrs.ChecksumCRC32C = aws.ToString(head.ChecksumCRC32C)
+		attrs.ChecksumSHA256 = aws.ToString(head.ChecksumSHA256)
+	}
+	return attrs
 }
 
 type commonPrefix struct {
@@ -258,7 +478,7 @@ func (fs *FileSystem) ReadDir(ctx context.Context, path string) ([]os.FileInfo,
 		prefixes := page.CommonPrefixes
 		for len(contents) > 0 && len(prefixes) > 0 {
 			if aws.ToString(contents[0].Key) < aws.ToString(prefixes[0].Prefix) {
-				res = append(res, object{contents[0]})
+				res = append(res, object{obj: contents[0]})
 				contents = contents[1:]
 			} else {
 				res = append(res, commonPrefix{prefixes[0]})
@@ -266,12 +486,17 @@ func (fs *FileSystem) ReadDir(ctx context.Context, path string) ([]os.FileInfo,
 			}
 		}
 		for _, v := range contents {
-			res = append(res, object{v})
+			res = append(res, object{obj: v})
 		}
 		for _, v := range prefixes {
 			res = append(res, commonPrefix{v})
 		}
 	}
+	if fs.ListMetadata || vfs.MetadataFromContext(ctx) {
+		if err := fs.listMetadata(ctx, path, res); err != nil {
+			return nil, err
+		}
+	}
 	// TODO error handling
 	// if err := pager.Err(); err != nil {
 	// 	if err, ok := err.(awserr.RequestFailure); ok {
@@ -314,29 +539,196 @@ func (fs *FileSystem) Create(ctx context.Context, name string, body io.Reader) e
 		}
 	}
 
+	var ifNoneMatch *string
+	if fs.noOverwrite(ctx, name) {
+		if stat != nil {
+			return &os.PathError{
+				Op:   "create",
+				Path: filename(name),
+				Err:  vfs.ErrConflict,
+			}
+		}
+		// S3 rejects the request if another session creates the object in the meantime.
+		ifNoneMatch = aws.String("*")
+	}
+
 	ext := pathpkg.Ext(name)
 	typ := mime.TypeByExtension(ext)
 	if typ == "" {
 		typ = "application/octet-stream"
 	}
 
+	if fs.MaxUploadSize > 0 {
+		body = &limitedReader{r: body, n: fs.MaxUploadSize}
+	}
+
+	tags, metadata, err := fs.uploadAttributes(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var h hash.Hash
+	if algorithm := hashAlgorithm(fs.ChecksumAlgorithm); algorithm != "" {
+		h, err = vfs.NewHash(algorithm)
+		if err != nil {
+			return err
+		}
+		body = io.TeeReader(body, h)
+	}
+
 	svc := fs.uploader()
-	_, err = svc.Upload(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(fs.Bucket),
-		Key:         aws.String(fs.filekey(name)),
-		Body:        body,
-		ContentType: aws.String(typ),
+	key := fs.filekey(name)
+	out, err := svc.Upload(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(fs.Bucket),
+		Key:               aws.String(key),
+		Body:              body,
+		ContentType:       aws.String(typ),
+		ChecksumAlgorithm: fs.ChecksumAlgorithm,
+		Tagging:           encodeTagging(tags),
+		Metadata:          metadata,
+		IfNoneMatch:       ifNoneMatch,
 	})
 	if err != nil {
+		var failure manager.MultiUploadFailure
+		if errors.As(err, &failure) {
+			fs.abortUpload(ctx, key, failure.UploadID())
+			liveUploads.remove(failure.UploadID())
+		}
+		if isConflict(err) {
+			err = vfs.ErrConflict
+		}
 		return &os.PathError{
 			Op:   "create",
 			Path: filename(name),
 			Err:  err,
 		}
 	}
+	liveUploads.remove(out.UploadID)
+	if h != nil {
+		return fs.verifyChecksum(ctx, name, out, h.Sum(nil), tags)
+	}
 	return nil
 }
 
+// abortUpload aborts the multipart upload.
+// It works even if ctx is already canceled, because canceling the upload is
+// the most common reason of aborting.
+func (fs *FileSystem) abortUpload(ctx context.Context, key, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+
+	svc := fs.s3()
+	_, err := svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// timeout for aborting multipart uploads
+var abortTimeout = 30 * time.Second
+
+// the multipart uploads in progress in this process.
+// they are shared by all FileSystems, because their prefixes may overlap on the same bucket.
+var liveUploads = &uploadSet{ids: map[string]struct{}{}}
+
+type uploadSet struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func (s *uploadSet) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[id] = struct{}{}
+}
+
+func (s *uploadSet) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, id)
+}
+
+func (s *uploadSet) contains(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ids[id]
+	return ok
+}
+
+// uploadTracker records the multipart uploads initiated by the uploader in liveUploads.
+type uploadTracker struct {
+	manager.UploadAPIClient
+}
+
+func (t uploadTracker) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	out, err := t.UploadAPIClient.CreateMultipartUpload(ctx, params, optFns...)
+	if err == nil {
+		liveUploads.add(aws.ToString(out.UploadId))
+	}
+	return out, err
+}
+
+// AbortIncompleteUploads aborts the multipart uploads under Prefix
+// that were initiated more than olderThan ago.
+// They are left when the server crashes during uploading files.
+// The uploads in progress in this process are skipped, but the ones of the other processes are not known,
+// so olderThan must be longer than the longest upload if the other processes share the bucket.
+// It returns the number of aborted uploads.
+func (fs *FileSystem) AbortIncompleteUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	svc := fs.s3()
+	deadline := time.Now().Add(-olderThan)
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(fs.Bucket),
+		Prefix: aws.String(fs.dirkey("")),
+	}
+	var count int
+	for {
+		resp, err := svc.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return count, err
+		}
+		for _, upload := range resp.Uploads {
+			if !aws.ToTime(upload.Initiated).Before(deadline) || liveUploads.contains(aws.ToString(upload.UploadId)) {
+				continue
+			}
+			if err := fs.abortUpload(ctx, aws.ToString(upload.Key), aws.ToString(upload.UploadId)); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if !aws.ToBool(resp.IsTruncated) {
+			return count, nil
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+// limitedReader reads from r but fails with vfs.ErrFileTooLarge
+// if r has more than n bytes.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, vfs.ErrFileTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		// read one more byte for checking the limit.
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, vfs.ErrFileTooLarge
+	}
+	return n, err
+}
+
 // Mkdir creates a new directory. If name is already a directory, Mkdir
 // returns an error (that can be detected using os.IsExist).
 func (fs *FileSystem) Mkdir(ctx context.Context, name string) error {
@@ -2,20 +2,37 @@ package s3fs
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
 	"testing/iotest"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/shogo82148/s3ftpgateway/ftp/ftptest"
 	"github.com/shogo82148/s3ftpgateway/vfs"
+	"golang.org/x/text/unicode/norm"
 )
 
 var _ vfs.FileSystem = &FileSystem{}
@@ -461,3 +478,973 @@ func TestRemove(t *testing.T) {
 		}
 	})
 }
+
+// fakeS3 is a fake S3 client for unit tests.
+// the methods that are not overridden panic.
+type fakeS3 struct {
+	s3client
+
+	mu      sync.Mutex
+	uploads []types.MultipartUpload
+	aborted []string
+
+	// objects are contents of the objects, served by GetObject.
+	objects map[string]string
+	// the ranges that fail once.
+	brokenRanges map[string]bool
+	// the ranges requested by GetObject, "" means whole of the object.
+	gets []string
+
+	// checksums of the objects, and their tags.
+	checksums map[string]string
+	tags      map[string][]types.Tag
+
+	// the tagging and the metadata set by PutObject.
+	tagging  map[string]string
+	metadata map[string]map[string]string
+
+	uploadPart func(ctx context.Context, params *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+}
+
+// responseError returns an error of the HTTP status code.
+func responseError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{
+				Response: &http.Response{StatusCode: status},
+			},
+			Err: errors.New(http.StatusText(status)),
+		},
+	}
+}
+
+// fakeETag returns the ETag of the body.
+func fakeETag(body string) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum([]byte(body)))
+}
+
+func (c *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := aws.ToString(params.Prefix)
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	contents := make([]types.Object, 0, len(keys))
+	for _, key := range keys {
+		contents = append(contents, types.Object{
+			Key:  aws.String(key),
+			ETag: aws.String(fakeETag(c.objects[key])),
+			Size: aws.Int64(int64(len(c.objects[key]))),
+		})
+	}
+	return &s3.ListObjectsV2Output{
+		Contents: contents,
+		KeyCount: aws.Int32(int32(len(contents))),
+	}, nil
+}
+
+func (c *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	source, err := url.PathUnescape(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	_, key, _ := strings.Cut(source, "/")
+	body, ok := c.objects[key]
+	if !ok {
+		return nil, responseError(http.StatusNotFound)
+	}
+	if params.CopySourceIfMatch != nil && aws.ToString(params.CopySourceIfMatch) != fakeETag(body) {
+		return nil, responseError(http.StatusPreconditionFailed)
+	}
+	c.objects[aws.ToString(params.Key)] = body
+	if c.metadata == nil {
+		c.metadata = map[string]map[string]string{}
+	}
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		c.metadata[aws.ToString(params.Key)] = params.Metadata
+	} else {
+		c.metadata[aws.ToString(params.Key)] = c.metadata[key]
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (c *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (c *fakeS3) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload-id"),
+	}, nil
+}
+
+func (c *fakeS3) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if c.uploadPart != nil {
+		return c.uploadPart(ctx, params)
+	}
+	if _, err := io.Copy(io.Discard, params.Body); err != nil {
+		return nil, err
+	}
+	return &s3.UploadPartOutput{}, nil
+}
+
+func (c *fakeS3) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *fakeS3) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aborted = append(c.aborted, aws.ToString(params.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *fakeS3) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return &s3.ListMultipartUploadsOutput{
+		Uploads: c.uploads,
+	}, nil
+}
+
+func (c *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gets = append(c.gets, aws.ToString(params.Range))
+	body := c.objects[aws.ToString(params.Key)]
+	etag := fakeETag(body)
+	if params.IfMatch != nil && aws.ToString(params.IfMatch) != etag {
+		return nil, errors.New("precondition failed")
+	}
+	if params.Range == nil {
+		return &s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(body)),
+			ETag: aws.String(etag),
+		}, nil
+	}
+
+	rng := aws.ToString(params.Range)
+	var start, end int
+	if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+		end = len(body) - 1
+	}
+	if start >= len(body) {
+		return nil, responseError(http.StatusRequestedRangeNotSatisfiable)
+	}
+	if end >= len(body) {
+		end = len(body) - 1
+	}
+	var r io.Reader = strings.NewReader(body[start : end+1])
+	if c.brokenRanges[rng] {
+		// the connection is reset while reading the body.
+		delete(c.brokenRanges, rng)
+		r = io.MultiReader(io.LimitReader(r, 1), iotest.ErrReader(errors.New("connection reset")))
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(r),
+		ETag:          aws.String(etag),
+		ContentLength: aws.Int64(int64(end - start + 1)),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(body))),
+	}, nil
+}
+
+func (c *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var body strings.Builder
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, &body), params.Body); err != nil {
+		return nil, err
+	}
+	sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := aws.ToString(params.Key)
+	if _, ok := c.objects[key]; ok && aws.ToString(params.IfNoneMatch) == "*" {
+		return nil, responseError(http.StatusPreconditionFailed)
+	}
+	if c.objects == nil {
+		c.objects = map[string]string{}
+	}
+	c.objects[key] = body.String()
+	if c.checksums == nil {
+		c.checksums = map[string]string{}
+	}
+	c.checksums[aws.ToString(params.Key)] = sum
+	if c.tagging == nil {
+		c.tagging = map[string]string{}
+	}
+	c.tagging[aws.ToString(params.Key)] = aws.ToString(params.Tagging)
+	if c.metadata == nil {
+		c.metadata = map[string]map[string]string{}
+	}
+	c.metadata[aws.ToString(params.Key)] = params.Metadata
+	return &s3.PutObjectOutput{
+		ChecksumSHA256: aws.String(sum),
+	}, nil
+}
+
+func (c *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := aws.ToString(params.Key)
+	body := c.objects[key]
+	return &s3.HeadObjectOutput{
+		ContentLength:  aws.Int64(int64(len(body))),
+		ETag:           aws.String(fakeETag(body)),
+		ChecksumSHA256: aws.String(c.checksums[key]),
+		Metadata:       c.metadata[key],
+	}, nil
+}
+
+func (c *fakeS3) PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags == nil {
+		c.tags = map[string][]types.Tag{}
+	}
+	c.tags[aws.ToString(params.Key)] = params.Tagging.TagSet
+	return &s3.PutObjectTaggingOutput{}, nil
+}
+
+func (c *fakeS3) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &s3.GetObjectTaggingOutput{
+		TagSet: c.tags[aws.ToString(params.Key)],
+	}, nil
+}
+
+func TestCreate_Checksum(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cases := []struct {
+		name string
+		size int64
+	}{
+		{"single-part", 1024},
+		{"multipart", 2*manager.MinUploadPartSize + 1024},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := &fakeS3{}
+			fs := &FileSystem{
+				Bucket:            "bucket",
+				PartSize:          manager.MinUploadPartSize,
+				ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+				s3api:             svc,
+			}
+			if err := fs.Create(ctx, "foo.txt", io.LimitReader(zeroReader{}, c.size)); err != nil {
+				t.Fatal(err)
+			}
+
+			h := sha256.New()
+			io.CopyN(h, zeroReader{}, c.size)
+			want := hex.EncodeToString(h.Sum(nil))
+			got, err := fs.Hash(ctx, "foo.txt", vfs.HashSHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestStat_Checksum(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{}
+	fs := &FileSystem{
+		Bucket:            "bucket",
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		s3api:             svc,
+	}
+	if err := fs.Create(ctx, "foo.txt", strings.NewReader("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := fs.Stat(vfs.WithMetadata(ctx), "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, ok := stat.Sys().(*ObjectAttributes)
+	if !ok {
+		t.Fatalf("want *ObjectAttributes, got %T", stat.Sys())
+	}
+	h := sha256.Sum256([]byte("foo"))
+	want := &ObjectAttributes{
+		Key:            "foo.txt",
+		ETag:           fakeETag("foo"),
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(h[:]),
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("want %#v, got %#v", want, attrs)
+	}
+}
+
+func TestHash_ETag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{
+		objects: map[string]string{
+			"foo.txt": "foo",
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		s3api:  svc,
+	}
+
+	got, err := fs.Hash(ctx, "foo.txt", vfs.HashMD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "acbd18db4cc2f85cedef654fccc4a4d8"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	// the checksum is not stored.
+	if _, err := fs.Hash(ctx, "foo.txt", vfs.HashSHA1); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("want ErrUnsupported, got %v", err)
+	}
+}
+
+func TestCreate_UploadRules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = vfs.WithSession(ctx, &vfs.Session{
+		ID:       "0123abcd",
+		User:     "alice",
+		ClientIP: "192.0.2.1",
+		Listener: "public",
+	})
+
+	rules := []UploadRule{
+		{
+			Tags: map[string]*template.Template{
+				"uploader": template.Must(template.New("").Parse("{{.User}}")),
+				"listener": template.Must(template.New("").Parse("{{.Listener}}")),
+			},
+			Metadata: map[string]*template.Template{
+				"session": template.Must(template.New("").Parse("{{.SessionID}}@{{.ClientIP}}")),
+			},
+		},
+		{
+			User:       "alice",
+			PathPrefix: "/incoming",
+			Tags: map[string]*template.Template{
+				"listener": template.Must(template.New("").Parse("incoming-{{.Listener}}")),
+			},
+		},
+		{
+			User: "bob",
+			Tags: map[string]*template.Template{
+				"uploader": template.Must(template.New("").Parse("bob")),
+			},
+		},
+	}
+
+	t.Run("single-part", func(t *testing.T) {
+		svc := &fakeS3{}
+		fs := &FileSystem{
+			Bucket:      "bucket",
+			UploadRules: rules,
+			s3api:       svc,
+		}
+		if err := fs.Create(ctx, "incoming/foo.txt", strings.NewReader("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := svc.tagging["incoming/foo.txt"], "listener=incoming-public&uploader=alice"; got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+		if got, want := svc.metadata["incoming/foo.txt"]["session"], "0123abcd@192.0.2.1"; got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+
+		if err := fs.Create(ctx, "incoming-foo.txt", strings.NewReader("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := svc.tagging["incoming-foo.txt"], "listener=public&uploader=alice"; got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		svc := &fakeS3{}
+		fs := &FileSystem{
+			Bucket:            "bucket",
+			PartSize:          manager.MinUploadPartSize,
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			UploadRules:       rules,
+			s3api:             svc,
+		}
+		size := int64(2*manager.MinUploadPartSize + 1024)
+		if err := fs.Create(ctx, "foo.txt", io.LimitReader(zeroReader{}, size)); err != nil {
+			t.Fatal(err)
+		}
+
+		// the checksum tag must not remove the tags of the rules.
+		got := map[string]string{}
+		for _, tag := range svc.tags["foo.txt"] {
+			got[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if got["uploader"] != "alice" || got["listener"] != "public" {
+			t.Errorf("unexpected tags: %v", got)
+		}
+		if _, ok := got[checksumTagKey(vfs.HashSHA256)]; !ok {
+			t.Errorf("checksum tag not found: %v", got)
+		}
+	})
+}
+
+func TestCreate_NoOverwrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{
+		objects: map[string]string{
+			"incoming/foo.txt": "foo",
+			"outgoing/foo.txt": "foo",
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		UploadRules: []UploadRule{
+			{
+				PathPrefix:  "/incoming",
+				NoOverwrite: true,
+			},
+		},
+		s3api: svc,
+	}
+
+	err := fs.Create(ctx, "incoming/foo.txt", strings.NewReader("bar"))
+	if !errors.Is(err, vfs.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+	if got := svc.objects["incoming/foo.txt"]; got != "foo" {
+		t.Errorf("the object is overwritten: %q", got)
+	}
+	if err := fs.Create(ctx, "outgoing/foo.txt", strings.NewReader("bar")); err != nil {
+		t.Error(err)
+	}
+	if err := fs.Create(ctx, "incoming/bar.txt", strings.NewReader("bar")); err != nil {
+		t.Error(err)
+	}
+
+	// another session creates the object while uploading.
+	svc.objects["incoming/baz.txt"] = "baz"
+	err = fs.Create(ctx, "incoming/baz.txt", strings.NewReader("bar"))
+	if !errors.Is(err, vfs.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+}
+
+func TestCreate_NoOverwriteNormalized(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// "café.txt" uploaded by a macOS client.
+	nfc := norm.NFC.String("café.txt")
+	nfd := norm.NFD.String("café.txt")
+	svc := &fakeS3{
+		objects: map[string]string{
+			"incoming/" + nfd: "NFD",
+		},
+	}
+	fs := vfs.Normalize(&FileSystem{
+		Bucket: "bucket",
+		UploadRules: []UploadRule{
+			{
+				PathPrefix:  "/incoming",
+				NoOverwrite: true,
+			},
+		},
+		s3api: svc,
+	}, norm.NFC)
+
+	err := fs.Create(ctx, "/incoming/"+nfc, strings.NewReader("NFC"))
+	if !errors.Is(err, vfs.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+	if got := svc.objects["incoming/"+nfd]; got != "NFD" {
+		t.Errorf("the object in NFD is overwritten or removed: %q", got)
+	}
+	if _, ok := svc.objects["incoming/"+nfc]; ok {
+		t.Errorf("the object in NFC is created")
+	}
+}
+
+func TestRename(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{
+		objects: map[string]string{
+			"foo.txt": "foo",
+			"bar.txt": "bar",
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		UploadRules: []UploadRule{
+			{
+				PathPrefix:  "/bar.txt",
+				NoOverwrite: true,
+			},
+		},
+		s3api: svc,
+	}
+
+	stat, err := fs.Stat(ctx, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := vfs.ETag(stat)
+	if etag == "" {
+		t.Fatal("want ETag, got empty")
+	}
+
+	// the file is changed by another session.
+	svc.objects["foo.txt"] = "foo foo"
+	if err := fs.Rename(ctx, "foo.txt", "baz.txt", etag); !errors.Is(err, vfs.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+
+	// the destination already exists.
+	if err := fs.Rename(ctx, "foo.txt", "bar.txt", ""); !errors.Is(err, vfs.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+
+	if err := fs.Rename(ctx, "foo.txt", "baz.txt", fakeETag("foo foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.objects["foo.txt"]; ok {
+		t.Error("foo.txt is not removed")
+	}
+	if got := svc.objects["baz.txt"]; got != "foo foo" {
+		t.Errorf("want %q, got %q", "foo foo", got)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{
+		objects: map[string]string{
+			"foo.txt": "foo",
+		},
+		metadata: map[string]map[string]string{
+			"foo.txt": {"user": "alice", "uid": "1000", "gid": "100"},
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		s3api:  svc,
+	}
+
+	if err := fs.Chmod(ctx, "foo.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := fs.Chtimes(ctx, "foo.txt", mtime, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	btime := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := fs.Chtimes(ctx, "foo.txt", time.Time{}, btime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"user":  "alice",
+		"uid":   "1000",
+		"gid":   "100",
+		"mode":  "33152", // 0100600
+		"mtime": "1577934245",
+		"btime": "1546398245",
+	}
+	if got := svc.metadata["foo.txt"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// the metadata are not in Stat by default.
+	stat, err := fs.Stat(ctx, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode() == 0600 || stat.ModTime().Equal(mtime) {
+		t.Errorf("want the mode and the time of the object, got %v %v", stat.Mode(), stat.ModTime())
+	}
+	stat, err = fs.Stat(vfs.WithMetadata(ctx), "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode() != 0600 {
+		t.Errorf("want %v, got %v", os.FileMode(0600), stat.Mode())
+	}
+	if !stat.ModTime().Equal(mtime) {
+		t.Errorf("want %v, got %v", mtime, stat.ModTime())
+	}
+	if got := vfs.CreateTime(stat); !got.Equal(btime) {
+		t.Errorf("want %v, got %v", btime, got)
+	}
+	if owner, group := vfs.Owner(stat); owner != "1000" || group != "100" {
+		t.Errorf("want 1000:100, got %s:%s", owner, group)
+	}
+
+	// the metadata are not in listings by default.
+	list, err := fs.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ModTime().Equal(mtime) {
+		t.Errorf("want the time of the object, got %v", list)
+	}
+	fs.ListMetadata = true
+	list, err = fs.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !list[0].ModTime().Equal(mtime) || list[0].Mode() != 0600 {
+		t.Errorf("want the time and the mode from the metadata, got %v", list)
+	}
+
+	if err := fs.Chmod(ctx, "bar.txt", 0600); !os.IsNotExist(err) {
+		t.Errorf("want not exist, got %v", err)
+	}
+}
+
+func TestMfmtMlsd(t *testing.T) {
+	svc := &fakeS3{
+		objects: map[string]string{
+			"foo.txt": "foo",
+		},
+	}
+	ts := ftptest.NewUnstartedServer(&FileSystem{
+		Bucket: "bucket",
+		s3api:  svc,
+	})
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Dial(t)
+	defer c.Close()
+	c.Login("ftp", "foobar@example.com")
+	c.Cmd(213, "MFMT 20200102030405 foo.txt")
+	if msg := c.Cmd(213, "MDTM foo.txt"); msg != "20200102030405" {
+		t.Errorf("unexpected MDTM reply: %q", msg)
+	}
+
+	// MLSD shows the time set by MFMT, even if ListMetadata is disabled.
+	data := c.Passive()
+	c.Cmd(150, "MLSD")
+	list, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c.Cmd(226, "")
+	if !strings.Contains(string(list), "Modify=20200102030405;") {
+		t.Errorf("MLSD doesn't show the time set by MFMT: %q", list)
+	}
+}
+
+func TestOpenOffset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := strings.Repeat("0123456789", 10) + "abc"
+	cases := []struct {
+		name        string
+		concurrency int
+		offset      int64
+		want        string
+	}{
+		{"single", 1, 35, content[35:]},
+		{"parallel", 4, 35, content[35:]},
+		{"single-beyond-eof", 1, 200, ""},
+		{"parallel-beyond-eof", 4, 200, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := &fakeS3{
+				objects: map[string]string{
+					"foo.txt": content,
+				},
+			}
+			fs := &FileSystem{
+				Bucket:              "bucket",
+				DownloadConcurrency: c.concurrency,
+				DownloadPartSize:    10,
+				s3api:               svc,
+			}
+			r, err := fs.OpenOffset(ctx, "foo.txt", c.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestOpen_Parallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := strings.Repeat("0123456789", 10) + "abc"
+	cases := []struct {
+		name      string
+		threshold int64
+		gets      int
+	}{
+		{"parallel", 0, 12},
+		{"below-threshold", 1000, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := &fakeS3{
+				objects: map[string]string{
+					"foo.txt": content,
+				},
+				brokenRanges: map[string]bool{
+					"bytes=30-39": true,
+				},
+			}
+			fs := &FileSystem{
+				Bucket:              "bucket",
+				DownloadConcurrency: 3,
+				DownloadPartSize:    10,
+				DownloadThreshold:   c.threshold,
+				s3api:               svc,
+			}
+			r, err := fs.Open(ctx, "foo.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != content {
+				t.Errorf("want %q, got %q", content, string(b))
+			}
+			if len(svc.gets) != c.gets {
+				t.Errorf("want %d GET requests, got %v", c.gets, svc.gets)
+			}
+		})
+	}
+}
+
+func TestCreate_Abort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{
+		uploadPart: func(ctx context.Context, params *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			// the client disconnects during the upload.
+			cancel()
+			return nil, ctx.Err()
+		},
+	}
+	fs := &FileSystem{
+		Bucket:   "bucket",
+		PartSize: manager.MinUploadPartSize,
+		s3api:    svc,
+	}
+
+	body := io.LimitReader(zeroReader{}, 3*manager.MinUploadPartSize)
+	if err := fs.Create(ctx, "foo.txt", body); err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if len(svc.aborted) != 1 || svc.aborted[0] != "upload-id" {
+		t.Errorf("want the upload is aborted, got %v", svc.aborted)
+	}
+}
+
+func TestCreate_MaxUploadSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &fakeS3{}
+	fs := &FileSystem{
+		Bucket:        "bucket",
+		PartSize:      manager.MinUploadPartSize,
+		MaxUploadSize: 2 * manager.MinUploadPartSize,
+		s3api:         svc,
+	}
+
+	body := io.LimitReader(zeroReader{}, 3*manager.MinUploadPartSize)
+	err := fs.Create(ctx, "foo.txt", body)
+	if !errors.Is(err, vfs.ErrFileTooLarge) {
+		t.Fatalf("want vfs.ErrFileTooLarge, got %v", err)
+	}
+	if len(svc.aborted) != 1 {
+		t.Errorf("want the upload is aborted, got %v", svc.aborted)
+	}
+
+	body = io.LimitReader(zeroReader{}, 2*manager.MinUploadPartSize)
+	if err := fs.Create(ctx, "foo.txt", body); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+}
+
+func TestAbortIncompleteUploads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	svc := &fakeS3{
+		uploads: []types.MultipartUpload{
+			{
+				Key:       aws.String("prefix/old.txt"),
+				UploadId:  aws.String("old"),
+				Initiated: aws.Time(now.Add(-48 * time.Hour)),
+			},
+			{
+				Key:       aws.String("prefix/new.txt"),
+				UploadId:  aws.String("new"),
+				Initiated: aws.Time(now.Add(-time.Minute)),
+			},
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		Prefix: "prefix",
+		s3api:  svc,
+	}
+
+	n, err := fs.AbortIncompleteUploads(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1, got %d", n)
+	}
+	if len(svc.aborted) != 1 || svc.aborted[0] != "old" {
+		t.Errorf("want old is aborted, got %v", svc.aborted)
+	}
+}
+
+func TestAbortIncompleteUploads_InProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fs *FileSystem
+	var aborted int
+	svc := &fakeS3{
+		uploads: []types.MultipartUpload{
+			{
+				Key:       aws.String("foo.txt"),
+				UploadId:  aws.String("upload-id"),
+				Initiated: aws.Time(time.Now().Add(-48 * time.Hour)),
+			},
+		},
+		uploadPart: func(ctx context.Context, params *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			if _, err := io.Copy(io.Discard, params.Body); err != nil {
+				return nil, err
+			}
+			if aws.ToInt32(params.PartNumber) == 1 {
+				// the sweeper runs during the upload.
+				n, err := fs.AbortIncompleteUploads(ctx, 24*time.Hour)
+				if err != nil {
+					return nil, err
+				}
+				aborted = n
+			}
+			return &s3.UploadPartOutput{}, nil
+		},
+	}
+	fs = &FileSystem{
+		Bucket:      "bucket",
+		PartSize:    manager.MinUploadPartSize,
+		Concurrency: 1,
+		s3api:       svc,
+	}
+
+	body := io.LimitReader(zeroReader{}, 2*manager.MinUploadPartSize)
+	if err := fs.Create(ctx, "foo.txt", body); err != nil {
+		t.Fatal(err)
+	}
+	if aborted != 0 || len(svc.aborted) != 0 {
+		t.Errorf("want the upload in progress is not aborted, got %v", svc.aborted)
+	}
+
+	// the upload is finished, so it is not in progress anymore.
+	n, err := fs.AbortIncompleteUploads(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1, got %d", n)
+	}
+}
+
+func TestFindNormalizationCollisions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nfc := norm.NFC.String("café")
+	nfd := norm.NFD.String("café")
+	svc := &fakeS3{
+		objects: map[string]string{
+			"prefix/" + nfc + ".txt":   "",
+			"prefix/" + nfd + ".txt":   "",
+			"prefix/" + nfc + "/a.txt": "",
+			"prefix/" + nfd + "/b.txt": "",
+			"prefix/other.txt":         "",
+		},
+	}
+	fs := &FileSystem{
+		Bucket: "bucket",
+		Prefix: "prefix",
+		s3api:  svc,
+	}
+
+	got, err := fs.FindNormalizationCollisions(ctx, norm.NFC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{nfd + ".txt", nfc + ".txt"},
+		{nfd + "/", nfc + "/"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,36 @@
+package vfs
+
+import "context"
+
+// Session is the identity of the client session
+// that the file system operation is performed on behalf of.
+type Session struct {
+	// ID is the unique identifier of the session.
+	ID string
+
+	// User is the name of the logged-in user.
+	// It is empty before login.
+	User string
+
+	// ClientIP is the IP address of the client.
+	ClientIP string
+
+	// Listener is the name of the listener that accepted the connection.
+	Listener string
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx with the session.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext returns the session associated with ctx.
+// If ctx has no session, it returns an empty session.
+func SessionFromContext(ctx context.Context) *Session {
+	if s, ok := ctx.Value(sessionKey{}).(*Session); ok && s != nil {
+		return s
+	}
+	return &Session{}
+}
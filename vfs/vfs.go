@@ -5,10 +5,20 @@ package vfs
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 )
 
+// ErrFileTooLarge is returned by Create when the body exceeds
+// the maximum file size of the file system.
+var ErrFileTooLarge = errors.New("vfs: file too large")
+
+// ErrConflict is returned when a conditional write fails,
+// e.g. the file already exists and overwriting is not allowed,
+// or the file was changed by another session.
+var ErrConflict = errors.New("vfs: conflict")
+
 // The FileSystem interface specifies the methods used to access the
 // file system.
 type FileSystem interface {